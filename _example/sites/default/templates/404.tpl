@@ -0,0 +1,15 @@
+{{ template "header.tpl" . }}
+
+  <body>
+
+  {{ template "sidebar.tpl" . }}
+
+    <div class="content container">
+      <h1>{{ .Title }}</h1>
+
+      <p>The page you were looking for doesn't exist; try the menu or the
+      search box instead.</p>
+    </div>
+
+  </body>
+</html>
@@ -0,0 +1,18 @@
+{{ template "header.tpl" . }}
+
+  <body>
+
+  {{ template "sidebar.tpl" . }}
+
+    <div class="content container">
+      <h1>{{ .Title }}</h1>
+
+      <p>Something went wrong while preparing this page.</p>
+
+      {{ if .Error }}
+        <pre>{{ .Error }}</pre>
+      {{ end }}
+    </div>
+
+  </body>
+</html>
@@ -25,6 +25,7 @@
         {{ end }}
       {{ end }}
     </title>
+    {{ with .URL }}<link rel="canonical" href="{{ . }}">{{ end }}
 
     <!-- CSS -->
     <link rel="stylesheet" href="{{ asset "/css/poole.css" }}">
@@ -43,9 +43,9 @@
 
     </div>
 
-  {{ if .Site.page.body.scripts.footer }}
-    <script type="text/javascript">
-      {{ .Settigns.page.body.scripts.footer | js }}
+  {{ if .Site.Page.Body.Scripts.Footer }}
+    <script type="text/javascript"{{ if .Nonce }} nonce="{{ .Nonce }}"{{ end }}>
+      {{ .Site.Page.Body.Scripts.Footer | js }}
     </script>
   {{ end }}
 
@@ -27,7 +27,7 @@
           <ul>
           {{ range $res }}
             {{ $res := printf "%s" .StoreValue }}
-            <li><a href="{{ $res }}">{{ $res }}</a></li>
+            <li><a href="{{ asset $res }}">{{ $res }}</a></li>
           {{ end }}
           </ul>
         {{ end }}
@@ -13,7 +13,7 @@
 
         <div class="sidebar-about">
           <div class="logo">
-            <a href="/">
+            <a href="{{ asset "/" }}">
               <!--
               Icon made by OCHA (http://www.unocha.org) from www.flaticon.com
               is licensed under CC BY 3.0
@@ -28,7 +28,7 @@
             </a>
           </h1>
           <p class="lead">{{ .Site.Page.Body.Title }}</p>
-          <form action="/search" method="GET">
+          <form action="{{ asset "/search" }}" method="GET">
             <input type="text" name="terms"><br>
             <input type="submit" value="search">
           </form>
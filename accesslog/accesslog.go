@@ -0,0 +1,196 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package accesslog writes access log entries in common, combined or JSON
+// format to stdout or to files with optional rotation.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/to"
+)
+
+// Supported formats.
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+)
+
+// Entry holds the details of a served request.
+type Entry struct {
+	RemoteAddr string
+	User       string
+	Host       string
+	Time       time.Time
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Size       int
+	Referer    string
+	UserAgent  string
+	Duration   time.Duration
+}
+
+// NewEntry creates an entry for a request that started at start and was
+// answered with status and size bytes.
+func NewEntry(req *http.Request, start time.Time, status, size int) *Entry {
+	// Requests that came through FastCGI have no RequestURI.
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+	return &Entry{
+		RemoteAddr: req.RemoteAddr,
+		Host:       req.Host,
+		Time:       start,
+		Method:     req.Method,
+		URI:        uri,
+		Proto:      req.Proto,
+		Status:     status,
+		Size:       size,
+		Referer:    req.Referer(),
+		UserAgent:  req.UserAgent(),
+		Duration:   time.Since(start),
+	}
+}
+
+// Logger writes entries in one format to an output.
+type Logger struct {
+	format string
+	out    *output
+}
+
+// New creates a logger from an "access_log" settings section:
+//
+//	file:        path of the log file; "" or "stdout" for the standard output
+//	format:      "common" (default), "combined" or "json"
+//	max_size:    rotate after the file grows past this many megabytes
+//	rotate:      rotate every "hourly", "daily" or duration like "12h"
+//	max_backups: number of rotated files kept; 0 keeps them all
+//
+// Loggers sharing a file share the same output, using the rotation settings
+// of the most recently created one. Files are opened on the first write.
+func New(conf map[string]interface{}) (*Logger, error) {
+	format := to.String(conf["format"])
+	switch format {
+	case "":
+		format = FormatCommon
+	case FormatCommon, FormatCombined, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format: %s", format)
+	}
+
+	var interval time.Duration
+	switch rotate := to.String(conf["rotate"]); rotate {
+	case "":
+	case "hourly":
+		interval = time.Hour
+	case "daily":
+		interval = 24 * time.Hour
+	default:
+		var err error
+		if interval, err = time.ParseDuration(rotate); err != nil {
+			return nil, fmt.Errorf("invalid access log rotation %s: %v", rotate, err)
+		}
+	}
+
+	out := acquire(to.String(conf["file"]), to.Int64(conf["max_size"])<<20, interval, int(to.Int64(conf["max_backups"])))
+
+	return &Logger{format: format, out: out}, nil
+}
+
+// Log writes an entry.
+func (l *Logger) Log(e *Entry) {
+	var line string
+	switch l.format {
+	case FormatJSON:
+		line = jsonLine(e)
+	case FormatCombined:
+		line = commonLine(e) + " " + strconv.Quote(e.Referer) + " " + strconv.Quote(e.UserAgent)
+	default:
+		line = commonLine(e)
+	}
+	l.out.write([]byte(line + "\n"))
+}
+
+// Close releases the logger's output.
+func (l *Logger) Close() {
+	release(l.out)
+}
+
+// chunk returns value or "-" when it's empty.
+func chunk(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// commonLine formats an entry in the Common Log Format.
+func commonLine(e *Entry) string {
+	size := "-"
+	if e.Size > 0 {
+		size = strconv.Itoa(e.Size)
+	}
+	// Behind trusted proxies the address comes without a port.
+	remote := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(remote); err == nil {
+		remote = h
+	}
+	logLine := []string{
+		chunk(strings.Trim(remote, "[]")),
+		"-",
+		chunk(e.User),
+		"[" + e.Time.Format("02/Jan/2006:15:04:05 -0700") + "]",
+		"\"" + fmt.Sprintf("%s %s %s", e.Method, e.URI, e.Proto) + "\"",
+		strconv.Itoa(e.Status),
+		size,
+	}
+	return strings.Join(logLine, " ")
+}
+
+// jsonLine formats an entry as a JSON object.
+func jsonLine(e *Entry) string {
+	buf, _ := json.Marshal(map[string]interface{}{
+		"time":        e.Time.Format(time.RFC3339),
+		"remote_addr": e.RemoteAddr,
+		"user":        e.User,
+		"host":        e.Host,
+		"method":      e.Method,
+		"uri":         e.URI,
+		"proto":       e.Proto,
+		"status":      e.Status,
+		"bytes":       e.Size,
+		"referer":     e.Referer,
+		"user_agent":  e.UserAgent,
+		"duration_ms": float64(e.Duration) / float64(time.Millisecond),
+	})
+	return string(buf)
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package accesslog
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Suffix format of rotated files.
+const backupFormat = "20060102-150405"
+
+// output is a log file shared by all loggers that write to it.
+type output struct {
+	sync.Mutex
+	// Path of the file; empty for stdout.
+	path string
+	// Open file, nil until the first write or after a reopen.
+	file *os.File
+	// Size of the open file.
+	size int64
+	// Time the next rotation is due; zero without time-based rotation.
+	next time.Time
+	// Rotation settings.
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	// Number of loggers using the output.
+	refs int
+}
+
+var (
+	// Open outputs by path.
+	outputs     = map[string]*output{}
+	outputsLock sync.Mutex
+)
+
+// acquire returns the output for path, creating it if needed.
+func acquire(path string, maxSize int64, interval time.Duration, maxBackups int) *output {
+	if path == "stdout" || path == "-" {
+		path = ""
+	}
+	outputsLock.Lock()
+	defer outputsLock.Unlock()
+	out, ok := outputs[path]
+	if !ok {
+		out = &output{path: path}
+		outputs[path] = out
+	}
+	out.Lock()
+	out.maxSize, out.interval, out.maxBackups = maxSize, interval, maxBackups
+	out.Unlock()
+	out.refs++
+	return out
+}
+
+// release drops a reference to an output, closing it when it's unused.
+func release(out *output) {
+	outputsLock.Lock()
+	defer outputsLock.Unlock()
+	out.refs--
+	if out.refs > 0 {
+		return
+	}
+	delete(outputs, out.path)
+	out.Lock()
+	out.close()
+	out.Unlock()
+}
+
+// Reopen closes every log file so it's opened again on the next write, for
+// use after the files were moved by an external tool like logrotate.
+func Reopen() {
+	outputsLock.Lock()
+	defer outputsLock.Unlock()
+	for _, out := range outputs {
+		out.Lock()
+		out.close()
+		out.Unlock()
+	}
+}
+
+// write appends a line, opening and rotating the file as needed.
+func (out *output) write(line []byte) {
+	if out.path == "" {
+		os.Stdout.Write(line)
+		return
+	}
+
+	out.Lock()
+	defer out.Unlock()
+
+	if out.file != nil && out.due(len(line)) {
+		out.rotate()
+	}
+	if out.file == nil {
+		if err := out.open(); err != nil {
+			log.Printf("Error opening access log %s: %v\n", out.path, err)
+			return
+		}
+	}
+	n, err := out.file.Write(line)
+	out.size += int64(n)
+	if err != nil {
+		log.Printf("Error writing access log %s: %v\n", out.path, err)
+	}
+}
+
+// open opens the file for appending.
+func (out *output) open() error {
+	f, err := os.OpenFile(out.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	out.file = f
+	out.size = info.Size()
+	if out.interval > 0 {
+		out.next = time.Now().Truncate(out.interval).Add(out.interval)
+	}
+	return nil
+}
+
+// close closes the file if it's open.
+func (out *output) close() {
+	if out.file != nil {
+		out.file.Close()
+		out.file = nil
+	}
+}
+
+// due returns true when writing n more bytes calls for a rotation.
+func (out *output) due(n int) bool {
+	if out.maxSize > 0 && out.size+int64(n) > out.maxSize {
+		return true
+	}
+	return !out.next.IsZero() && !time.Now().Before(out.next)
+}
+
+// rotate renames the current file with a timestamp suffix and removes the
+// oldest backups beyond maxBackups. Backups made within the same second get
+// a sequence number after the timestamp, so none is overwritten.
+func (out *output) rotate() {
+	out.close()
+	stamp := out.path + "." + time.Now().Format(backupFormat)
+	backup := stamp
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%03d", stamp, n)
+	}
+	if err := os.Rename(out.path, backup); err != nil {
+		log.Printf("Error rotating access log %s: %v\n", out.path, err)
+		return
+	}
+	if out.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(out.path + ".[0-9]*")
+	if err != nil || len(backups) <= out.maxBackups {
+		return
+	}
+	// Timestamps and sequence numbers sort in chronological order.
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-out.maxBackups] {
+		os.Remove(old)
+	}
+}
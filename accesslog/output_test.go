@@ -0,0 +1,86 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package accesslog
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateSameSecond(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+	out := acquire(file, 10, 0, 0)
+	defer release(out)
+
+	// Every line is past max_size, so each write rotates the previous one.
+	lines := []string{"first line\n", "second line\n", "third line\n", "fourth line\n"}
+	for _, line := range lines {
+		out.write([]byte(line))
+	}
+
+	backups, err := filepath.Glob(file + ".[0-9]*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != len(lines)-1 {
+		t.Fatalf("got %d backups, want %d: %v", len(backups), len(lines)-1, backups)
+	}
+	var kept []string
+	for _, backup := range backups {
+		buf, err := ioutil.ReadFile(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept = append(kept, string(buf))
+	}
+	if got, want := strings.Join(kept, ""), strings.Join(lines[:len(lines)-1], ""); got != want {
+		t.Errorf("backups hold %q, want %q in order", got, want)
+	}
+}
+
+func TestCommonLineUser(t *testing.T) {
+	e := &Entry{RemoteAddr: "192.0.2.1:1234", User: "alice", Method: "GET", URI: "/", Proto: "HTTP/1.1", Status: 200}
+	if line := commonLine(e); !strings.HasPrefix(line, "192.0.2.1 - alice [") {
+		t.Errorf("commonLine = %q, want the user after the address", line)
+	}
+	e.User = ""
+	if line := commonLine(e); !strings.HasPrefix(line, "192.0.2.1 - - [") {
+		t.Errorf("commonLine = %q, want - for anonymous requests", line)
+	}
+}
+
+func TestNewEntryURI(t *testing.T) {
+	req := httptest.NewRequest("GET", "/docs/page?q=1", nil)
+	if e := NewEntry(req, time.Now(), 200, 0); e.URI != "/docs/page?q=1" {
+		t.Errorf("URI = %q, want /docs/page?q=1", e.URI)
+	}
+
+	// FastCGI requests only have a URL.
+	req.RequestURI = ""
+	if e := NewEntry(req, time.Now(), 200, 0); e.URI != "/docs/page?q=1" {
+		t.Errorf("URI without RequestURI = %q, want /docs/page?q=1", e.URI)
+	}
+}
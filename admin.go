@@ -0,0 +1,317 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/luminos/host"
+)
+
+// Default path prefix of the admin API.
+const envAdminPath = "/admin"
+
+// adminConfig holds the settings of the admin API.
+type adminConfig struct {
+	// Path prefix of the API; empty when the API is disabled.
+	path string
+	// Bearer token required for everything but health and readiness.
+	token string
+}
+
+var (
+	// Admin API settings, guarded by hostsLock.
+	admin adminConfig
+
+	// Time and outcome of the last settings.yaml load, guarded by hostsLock.
+	settingsLoaded time.Time
+	settingsErr    error
+
+	// Hosts being reindexed.
+	reindexing     = map[string]bool{}
+	reindexingLock sync.Mutex
+)
+
+// routeStatus describes a route for the admin API.
+type routeStatus struct {
+	Route     string `json:"route"`
+	Host      string `json:"host"`
+	Canonical string `json:"canonical,omitempty"`
+}
+
+// hostStatus describes a host and the routes leading to it.
+type hostStatus struct {
+	host.Status
+	Routes []string `json:"routes"`
+}
+
+// serverStatus describes the server as a whole.
+type serverStatus struct {
+	Version      string    `json:"version"`
+	Settings     string    `json:"settings"`
+	LastReload   time.Time `json:"last_reload"`
+	LastError    string    `json:"last_error,omitempty"`
+	Hosts        int       `json:"hosts"`
+	Reindexing   []string  `json:"reindexing"`
+	DefaultFound bool      `json:"default_host"`
+}
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(buf, '\n'))
+}
+
+// writeJSONError sends an error message as JSON.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// authorized checks the bearer token of a request.
+func (a adminConfig) authorized(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// serveAdminAPI answers requests under the admin path. The request path is
+// given relative to the admin path.
+func serveAdminAPI(w http.ResponseWriter, req *http.Request, a adminConfig, rel string) {
+	rel = "/" + strings.Trim(rel, "/")
+
+	// Health and readiness are left open for load balancers and
+	// orchestrators.
+	switch rel {
+	case "/health":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	case "/ready":
+		serveReady(w)
+		return
+	}
+
+	if !a.authorized(req) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="luminos"`)
+		writeJSONError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	switch {
+	case rel == "/" && req.Method == http.MethodGet:
+		serveServerStatus(w)
+	case rel == "/reload" && req.Method == http.MethodPost:
+		if err := reloadSettings(); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		serveServerStatus(w)
+	case rel == "/routes" && req.Method == http.MethodGet:
+		serveRoutes(w)
+	case rel == "/hosts" && req.Method == http.MethodGet:
+		serveHosts(w, "")
+	case strings.HasPrefix(rel, "/hosts/"):
+		name := strings.TrimPrefix(rel, "/hosts/")
+		switch {
+		case strings.HasSuffix(name, "/reload") && req.Method == http.MethodPost:
+			reloadHost(w, strings.TrimSuffix(name, "/reload"))
+		case strings.HasSuffix(name, "/reindex") && req.Method == http.MethodPost:
+			reindexHost(w, strings.TrimSuffix(name, "/reindex"))
+		case req.Method == http.MethodGet:
+			serveHosts(w, name)
+		default:
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// serveReady reports whether settings and all hosts loaded without errors.
+func serveReady(w http.ResponseWriter) {
+	hostsLock.RLock()
+	ready := settingsErr == nil && len(hosts) > 0
+	errs := []string{}
+	if settingsErr != nil {
+		errs = append(errs, settingsErr.Error())
+	}
+	for _, h := range hosts {
+		if s := h.Status(); s.LastError != "" {
+			ready = false
+			errs = append(errs, s.Name+": "+s.LastError)
+		}
+	}
+	hostsLock.RUnlock()
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	sort.Strings(errs)
+	writeJSON(w, status, map[string]interface{}{"ready": ready, "errors": errs})
+}
+
+// serveServerStatus describes the server.
+func serveServerStatus(w http.ResponseWriter) {
+	hostsLock.RLock()
+	s := serverStatus{
+		Version:    Version,
+		Settings:   *flagSettings,
+		LastReload: settingsLoaded,
+		Hosts:      len(hosts),
+		Reindexing: []string{},
+	}
+	if settingsErr != nil {
+		s.LastError = settingsErr.Error()
+	}
+	_, s.DefaultFound = hosts[defaultHost]
+	hostsLock.RUnlock()
+
+	reindexingLock.Lock()
+	for name := range reindexing {
+		s.Reindexing = append(s.Reindexing, name)
+	}
+	reindexingLock.Unlock()
+	sort.Strings(s.Reindexing)
+
+	writeJSON(w, http.StatusOK, s)
+}
+
+// serveRoutes lists the routes in the order they are matched.
+func serveRoutes(w http.ResponseWriter) {
+	hostsLock.RLock()
+	list := make([]routeStatus, 0, len(routes)+1)
+	for _, r := range routes {
+		list = append(list, routeStatus{Route: r.key, Host: r.host.Name, Canonical: r.canonical})
+	}
+	if _, ok := hosts[defaultHost]; ok {
+		list = append(list, routeStatus{Route: "*", Host: defaultHost})
+	}
+	hostsLock.RUnlock()
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// serveHosts describes all hosts, or only the named one.
+func serveHosts(w http.ResponseWriter, name string) {
+	hostsLock.RLock()
+	list := []hostStatus{}
+	for key, h := range hosts {
+		if name != "" && key != name {
+			continue
+		}
+		s := hostStatus{Status: h.Status(), Routes: []string{}}
+		for _, r := range routes {
+			if r.host == h {
+				s.Routes = append(s.Routes, r.key)
+			}
+		}
+		list = append(list, s)
+	}
+	hostsLock.RUnlock()
+
+	if name != "" {
+		if len(list) == 0 {
+			writeJSONError(w, http.StatusNotFound, "unknown host: "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, list[0])
+		return
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// lookupHost returns a host by its settings name.
+func lookupHost(name string) *host.Host {
+	hostsLock.RLock()
+	defer hostsLock.RUnlock()
+	return hosts[name]
+}
+
+// reloadHost reloads site.yaml and the templates of a host.
+func reloadHost(w http.ResponseWriter, name string) {
+	h := lookupHost(name)
+	if h == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown host: "+name)
+		return
+	}
+	log.Printf("%s: Reloading host on admin request.\n", name)
+	if err := h.Reload(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Status())
+}
+
+// reindexHost starts rebuilding the search index of a host in the
+// background.
+func reindexHost(w http.ResponseWriter, name string) {
+	h := lookupHost(name)
+	if h == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown host: "+name)
+		return
+	}
+
+	reindexingLock.Lock()
+	busy := reindexing[name]
+	reindexing[name] = true
+	reindexingLock.Unlock()
+
+	if busy {
+		writeJSONError(w, http.StatusConflict, "already reindexing: "+name)
+		return
+	}
+
+	// The files indexed aren't listed, since the standard output may be the
+	// access log.
+	go func() {
+		log.Printf("Reindexing %s.\n", name)
+		if err := indexHost(name, h, ioutil.Discard); err != nil {
+			log.Printf("Reindexing %s failed: %v\n", name, err)
+		} else {
+			log.Printf("Reindexed %s.\n", name)
+		}
+		reindexingLock.Lock()
+		delete(reindexing, name)
+		reindexingLock.Unlock()
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "reindexing", "host": name})
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to the admin API with token and returns the
+// response.
+func adminRequest(method, rel, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/admin"+rel, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	serveAdminAPI(w, req, adminConfig{path: "/admin", token: "secret"}, rel)
+	return w
+}
+
+func TestAdminAPI(t *testing.T) {
+	site := writeTestSite(t, map[string]string{"content/index.md": "# Home\n"})
+	docs := writeTestSite(t, map[string]string{"content/index.md": "# Docs\n"})
+	loadTestSettings(t, fmt.Sprintf("admin:\n  path: /api/\n  token: secret\nhosts:\n  default: %q\n  docs.example.org: %q\n", site, docs))
+
+	admin := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(req)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		token  string
+		code   int
+	}{
+		// Health and readiness don't need the token.
+		{http.MethodGet, "/api/health", "", http.StatusOK},
+		{http.MethodGet, "/api/ready", "", http.StatusOK},
+		{http.MethodGet, "/api/", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/api", "secret", http.StatusOK},
+		{http.MethodGet, "/api/hosts/docs.example.org", "secret", http.StatusOK},
+		{http.MethodGet, "/api/hosts/missing.example.org", "secret", http.StatusNotFound},
+		{http.MethodPost, "/api/hosts/missing.example.org/reindex", "secret", http.StatusNotFound},
+		{http.MethodDelete, "/api/hosts/docs.example.org", "secret", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/reload", "secret", http.StatusNotFound},
+		{http.MethodPost, "/api/reload", "secret", http.StatusOK},
+		// Other paths are still routed to the hosts.
+		{http.MethodGet, "/apis", "", http.StatusNotFound},
+		{http.MethodGet, "/", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		if w := admin(test.method, test.target, test.token); w.Code != test.code {
+			t.Errorf("%s %s = %d, want %d", test.method, test.target, w.Code, test.code)
+		}
+	}
+	if w := admin(http.MethodGet, "/api/", ""); w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("no WWW-Authenticate header without a token")
+	}
+
+	var status serverStatus
+	if err := json.Unmarshal(admin(http.MethodGet, "/api/", "secret").Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Hosts != 2 || !status.DefaultFound || status.LastError != "" {
+		t.Errorf("status = %+v", status)
+	}
+
+	var list []routeStatus
+	if err := json.Unmarshal(admin(http.MethodGet, "/api/routes", "secret").Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(list); n == 0 || list[n-1] != (routeStatus{Route: "*", Host: defaultHost}) {
+		t.Errorf("routes = %+v, want the default host last", list)
+	}
+
+	var hs []hostStatus
+	if err := json.Unmarshal(admin(http.MethodGet, "/api/hosts", "secret").Body.Bytes(), &hs); err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != 2 || hs[0].Name != defaultHost || hs[1].Name != "docs.example.org" {
+		t.Fatalf("hosts = %+v", hs)
+	}
+	if len(hs[1].Routes) == 0 || hs[1].Templates[0] != "index.tpl" {
+		t.Errorf("docs.example.org = %+v", hs[1])
+	}
+
+	// A host that fails to reload makes the server unready.
+	if w := admin(http.MethodPost, "/api/hosts/docs.example.org/reload", "secret"); w.Code != http.StatusOK {
+		t.Errorf("reload = %d, want 200", w.Code)
+	}
+	if err := ioutil.WriteFile(filepath.Join(docs, "site.yaml"), []byte("title: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := admin(http.MethodPost, "/api/hosts/docs.example.org/reload", "secret"); w.Code != http.StatusInternalServerError {
+		t.Errorf("broken reload = %d, want 500", w.Code)
+	}
+	if w := admin(http.MethodGet, "/api/ready", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ready = %d, want 503 after a failed reload", w.Code)
+	}
+}
+
+func TestAdminReindexBusy(t *testing.T) {
+	useHosts(t, newTestSite(t, defaultHost, map[string]string{"content/index.md": "# Home\n"}))
+
+	reindexingLock.Lock()
+	reindexing[defaultHost] = true
+	reindexingLock.Unlock()
+	defer func() {
+		reindexingLock.Lock()
+		delete(reindexing, defaultHost)
+		reindexingLock.Unlock()
+	}()
+
+	if w := adminRequest(http.MethodPost, "/hosts/default/reindex", "secret"); w.Code != http.StatusConflict {
+		t.Errorf("reindex = %d, want 409 while reindexing", w.Code)
+	}
+	var status serverStatus
+	if err := json.Unmarshal(adminRequest(http.MethodGet, "/", "secret").Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Reindexing) != 1 || status.Reindexing[0] != defaultHost {
+		t.Errorf("reindexing = %v, want [default]", status.Reindexing)
+	}
+}
+
+func TestAdminReindexQuiet(t *testing.T) {
+	h := newTestSite(t, defaultHost, map[string]string{
+		"content/index.md": "# Home\n",
+		"content/about.md": "# About\n",
+	})
+	useHosts(t, h)
+
+	// The standard output may be the access log.
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	if resp := adminRequest(http.MethodPost, "/hosts/default/reindex", "secret"); resp.Code != http.StatusAccepted {
+		t.Fatalf("reindex = %d, want 202", resp.Code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reindexingLock.Lock()
+		busy := reindexing[defaultHost]
+		reindexingLock.Unlock()
+		if !busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("reindexing didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	os.Stdout = stdout
+	w.Close()
+	if out, _ := ioutil.ReadAll(r); len(out) > 0 {
+		t.Errorf("reindexing wrote to the standard output: %q", out)
+	}
+	content, _ := h.GetContentPath()
+	if _, err := os.Stat(filepath.Join(content, "search.cdb")); err != nil {
+		t.Errorf("no index was written: %v", err)
+	}
+}
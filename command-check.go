@@ -0,0 +1,223 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/lnxjedi/cli"
+	"github.com/lnxjedi/dig"
+	"github.com/lnxjedi/luminos/accesslog"
+	"github.com/lnxjedi/luminos/host"
+	"github.com/lnxjedi/to"
+)
+
+// checkCommand is the structure that provides instructions for the
+// "luminos check" subcommand.
+type checkCommand struct {
+}
+
+// checkReport writes the problems found by "luminos check", grouped by the
+// settings file or host they belong to.
+type checkReport struct {
+	w        io.Writer
+	errors   int
+	warnings int
+}
+
+// section starts the problems of the settings file or a host.
+func (r *checkReport) section(title string) {
+	fmt.Fprintf(r.w, "\n%s\n", title)
+}
+
+func (r *checkReport) error(err error) {
+	r.errors++
+	fmt.Fprintf(r.w, "  error: %v\n", err)
+}
+
+func (r *checkReport) warning(msg string) {
+	r.warnings++
+	fmt.Fprintf(r.w, "  warning: %s\n", msg)
+}
+
+// Execute loads settings.yaml and every site the way "luminos run" does,
+// without serving them, and reports everything that's wrong. It fails when
+// there's any error.
+func (c *checkCommand) Execute() (err error) {
+	// If no settings file was specified, use the default.
+	if *flagSettings == "" {
+		*flagSettings = envSettingsFile
+	}
+
+	// Hosts log what they load; only the report is shown.
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	r := &checkReport{w: os.Stdout}
+	r.section(*flagSettings)
+
+	var y dig.InterfaceMap
+	ydata, err := ioutil.ReadFile(*flagSettings)
+	if err == nil {
+		err = yaml.Unmarshal(ydata, &y)
+	}
+	if err != nil {
+		r.error(err)
+		return r.finish()
+	}
+	settings = y
+
+	c.checkSettings(r, y)
+
+	// Sites in sites_dir that fail to load are skipped when serving, but
+	// they're still errors here.
+	entries, _, err := hostEntries(y)
+	if err != nil {
+		r.error(err)
+		return r.finish()
+	}
+	if _, ok := entries[defaultHost]; !ok {
+		r.warning("default host was not provided")
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := map[string]*host.Host{}
+	aliases := map[string][]string{}
+	canonical := map[string]bool{}
+	for _, name := range names {
+		r.section("host " + name)
+		path, err := hostEntry(name, entries[name], aliases, canonical)
+		if err != nil {
+			r.error(err)
+			continue
+		}
+		site, err := host.New(name, path)
+		if err != nil {
+			r.error(err)
+			continue
+		}
+		h[name] = site
+		for _, p := range site.Check() {
+			if p.Warning {
+				r.warning(p.String())
+			} else {
+				r.error(fmt.Errorf("%s", p))
+			}
+		}
+	}
+	defer func() {
+		for name := range h {
+			h[name].Close()
+		}
+	}()
+
+	// Routes can only be checked among the hosts that loaded.
+	if _, err := buildRoutes(h, aliases, canonical); err != nil {
+		r.section("routes")
+		r.error(err)
+	}
+
+	return r.finish()
+}
+
+// checkSettings checks the global sections of settings.yaml.
+func (c *checkCommand) checkSettings(r *checkReport, y dig.InterfaceMap) {
+	conf, _ := y.Get("access_log").(map[string]interface{})
+	if logger, err := accesslog.New(conf); err != nil {
+		r.error(fmt.Errorf("access_log: %v", err))
+	} else {
+		logger.Close()
+	}
+
+	if m, ok := y.Get("admin").(map[string]interface{}); ok && to.String(m["token"]) == "" {
+		r.warning("admin API disabled, no token was provided")
+	}
+
+	conf, _ = y.Get("compression").(map[string]interface{})
+	if _, err := loadCompression(conf); err != nil {
+		r.error(fmt.Errorf("compression: %v", err))
+	}
+
+	conf, _ = y.Get("proxies").(map[string]interface{})
+	if _, err := loadProxies(conf); err != nil {
+		r.error(fmt.Errorf("proxies: %v", err))
+	}
+
+	conf, _ = y.Get("rate_limit").(map[string]interface{})
+	if _, err := loadRateLimits(conf); err != nil {
+		r.error(fmt.Errorf("rate_limit: %v", err))
+	}
+
+	if to.Bool(y.Get("dev_mode")) {
+		r.warning("dev_mode shows error details to visitors")
+	}
+
+	if t := to.String(y.Get("server", "shutdown_timeout")); t != "" {
+		if _, err := time.ParseDuration(t); err != nil {
+			r.error(fmt.Errorf("invalid shutdown_timeout %s: %v", t, err))
+		}
+	}
+
+	listeners, err := listenerSettings()
+	if err != nil {
+		r.error(err)
+	}
+	for _, entry := range listeners {
+		if _, _, err := newServer(entry); err != nil {
+			r.error(err)
+		}
+	}
+}
+
+// finish writes the totals of the report and returns an error if there
+// were any errors.
+func (r *checkReport) finish() error {
+	fmt.Fprintf(r.w, "\n%d error(s), %d warning(s)\n", r.errors, r.warnings)
+	if r.errors > 0 {
+		return fmt.Errorf("%s has %d error(s)", *flagSettings, r.errors)
+	}
+	return nil
+}
+
+func init() {
+	// Describing the "check" subcommand.
+
+	cli.Register("check", cli.Entry{
+		Name:        "check",
+		Description: "Checks settings, sites, templates and content for errors.",
+		Arguments:   []string{"c"},
+		Command:     &checkCommand{},
+	})
+
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCheck runs "luminos check" on a settings file with content and returns
+// its report.
+func runCheck(t *testing.T, content string) (string, error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "settings.yaml")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved, stdout := *flagSettings, os.Stdout
+	*flagSettings = file
+	defer func() {
+		*flagSettings, os.Stdout = saved, stdout
+		settings = nil
+	}()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		done <- out
+	}()
+	err = (&checkCommand{}).Execute()
+	w.Close()
+	return string(<-done), err
+}
+
+func TestCheckCommand(t *testing.T) {
+	site := writeTestSite(t, map[string]string{
+		"content/index.md":     "# Home\n",
+		"content/search.cdb":   "",
+		"templates/search.tpl": "{{ .Content }}",
+	})
+	broken := writeTestSite(t, map[string]string{
+		"content/index.md": "---\n#luminos\nTitle: [\n---\n",
+	})
+
+	out, err := runCheck(t, fmt.Sprintf("server:\n  type: standalone\n  port: 0\nhosts:\n  default: %q\n", site))
+	if err != nil || !strings.HasSuffix(out, "\n0 error(s), 0 warning(s)\n") {
+		t.Errorf("clean settings: %v\n%s", err, out)
+	}
+
+	out, err = runCheck(t, fmt.Sprintf(`dev_mode: true
+compression:
+  level: 12
+server:
+  type: standalone
+  port: 0
+  shutdown_timeout: "soon"
+hosts:
+  docs.example.org: %q
+  missing.example.org: %q
+`, broken, filepath.Join(site, "missing")))
+	if err == nil {
+		t.Error("check succeeded on broken settings")
+	}
+	for _, want := range []string{
+		"  error: compression: invalid compression level: 12\n",
+		"  error: invalid shutdown_timeout soon: ",
+		"  warning: dev_mode shows error details to visitors\n",
+		"  warning: default host was not provided\n",
+		"\nhost docs.example.org\n",
+		"content/index.md: ",
+		"\nhost missing.example.org\n  error: ",
+		"\n4 error(s), 4 warning(s)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, out)
+		}
+	}
+
+	if out, err := runCheck(t, "hosts: ["); err == nil || !strings.Contains(out, "error: ") {
+		t.Errorf("unreadable settings: %v\n%s", err, out)
+	}
+}
@@ -66,20 +66,9 @@ func (c *indexCommand) Execute() (err error) {
 		return fmt.Errorf("error while reading settings file %s: %q", *flagSettings, err)
 	}
 
-	for name, host := range hosts {
-		content, err := host.GetContentPath()
-		if err == nil {
-			ifile, err := host.GetIndexPath()
-			if err != nil {
-				return fmt.Errorf("error getting index path for '%s': %v", name, err)
-			}
-			fmt.Printf("Creating '%s' for host '%s' with content path '%s'\n", ifile, name, content)
-			err = index(ifile, content)
-			if err != nil {
-				return fmt.Errorf("error indexing '%s': %v", name, err)
-			}
-		} else {
-			return fmt.Errorf("error locating content path for '%s': %v", name, err)
+	for name, h := range hosts {
+		if err := indexHost(name, h, os.Stdout); err != nil {
+			return err
 		}
 	}
 
@@ -0,0 +1,94 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/lnxjedi/cli"
+)
+
+// redirectsCommand is the structure that provides instructions for the
+// "luminos redirects" subcommand.
+type redirectsCommand struct {
+}
+
+// Execute lists the effective redirects of all the sites.
+func (c *redirectsCommand) Execute() (err error) {
+	var stat os.FileInfo
+
+	// If no settings file was specified, use the default.
+	if *flagSettings == "" {
+		*flagSettings = envSettingsFile
+	}
+
+	// Attempt to stat the settings file.
+	stat, err = os.Stat(*flagSettings)
+
+	// It must not return an error.
+	if err != nil {
+		return fmt.Errorf("error while opening %s: %q", *flagSettings, err)
+	}
+
+	// And the file must not be a directory.
+	if stat.IsDir() {
+		return fmt.Errorf("could not open %s: it's a directory", *flagSettings)
+	}
+
+	if settings, err = loadSettings(); err != nil {
+		return fmt.Errorf("error while reading settings file %s: %q", *flagSettings, err)
+	}
+
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tKIND\tFROM\tTO\tSTATUS\tSOURCE")
+	for _, name := range names {
+		for _, r := range hosts[name].Redirects() {
+			source := r.Source
+			if source == "" {
+				source = "site.yaml"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", name, r.Kind, r.From, r.To, r.Status, source)
+		}
+	}
+	return w.Flush()
+}
+
+func init() {
+	// Describing the "redirects" subcommand.
+
+	cli.Register("redirects", cli.Entry{
+		Name:        "redirects",
+		Description: "Lists the redirects of Luminos sites.",
+		Arguments:   []string{"c"},
+		Command:     &redirectsCommand{},
+	})
+
+}
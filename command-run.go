@@ -22,17 +22,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net"
-	"net/http"
-	"net/http/fcgi"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 
 	"github.com/lnxjedi/cli"
 	"github.com/lnxjedi/dig"
+	"github.com/lnxjedi/luminos/accesslog"
 	"github.com/lnxjedi/to"
 )
 
@@ -41,6 +43,11 @@ const (
 	envSettingsFile   = "./settings.yaml"
 	envServerDomain   = "unix"
 	envServerProtocol = "tcp"
+	// Time given to in-flight requests on shutdown.
+	envShutdownTimeout = 10 * time.Second
+	// Time allowed to read request headers, and to keep idle connections.
+	envReadHeaderTimeout = 10 * time.Second
+	envIdleTimeout       = 2 * time.Minute
 )
 
 // Global software settings.
@@ -87,78 +94,152 @@ func (c *runCommand) Execute() (err error) {
 	if settings, err = loadSettings(); err != nil {
 		return fmt.Errorf("error while reading settings file %s: %q", *flagSettings, err)
 	}
+	settingsLoaded = time.Now()
 
 	if *flagIndex {
-		for name, host := range hosts {
-			content, err := host.GetContentPath()
-			if err == nil {
-				ifile, err := host.GetIndexPath()
-				if err != nil {
-					return fmt.Errorf("error getting index path for '%s': %v", name, err)
-				}
-				fmt.Printf("Creating '%s' for host '%s' with content path '%s'\n", ifile, name, content)
-				err = index(ifile, content)
-				if err != nil {
-					return fmt.Errorf("error indexing '%s': %v", name, err)
-				}
-			} else {
-				return fmt.Errorf("error locating content path for '%s': %v", name, err)
+		for name, h := range hosts {
+			if err := indexHost(name, h, os.Stdout); err != nil {
+				return err
 			}
 		}
 	}
 
 	// Starting settings watcher.
 	if watch, err = settingsWatcher(); err == nil {
-		wd, _ := os.Getwd()
-		sf := path.Join(wd, *flagSettings)
+		sf := *flagSettings
+		if !path.IsAbs(sf) {
+			wd, _ := os.Getwd()
+			sf = path.Join(wd, sf)
+		}
 		err := watch.Add(sf)
 		if err != nil {
 			log.Fatalf("Error watching settings file: %v", err)
 		}
 	}
 
-	// Reading setttings.
-	serverType := to.String(settings.Get("server", "type"))
+	// Starting sites_dir watcher.
+	if sitesWatch, err = newSiteWatcher(); err == nil {
+		sitesWatch.watch(sitesDir)
+	} else {
+		log.Printf("Error watching sites_dir: %v", err)
+	}
 
-	domain := envServerDomain
-	address := to.String(settings.Get("server", "socket"))
+	timeout := envShutdownTimeout
+	if t := to.String(settings.Get("server", "shutdown_timeout")); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil {
+			return fmt.Errorf("invalid shutdown_timeout %s: %q", t, err)
+		}
+	}
 
-	if address == "" {
-		domain = envServerProtocol
-		address = fmt.Sprintf("%s:%d", to.String(settings.Get("server", "bind")), to.Int64(settings.Get("server", "port")))
+	// Reading listeners.
+	entries, err := listenerSettings()
+	if err != nil {
+		return err
 	}
 
-	// Creating a network listener.
-	var listener net.Listener
+	// Creating network listeners.
+	var services []service
 
-	if listener, err = net.Listen(domain, address); err != nil {
-		return fmt.Errorf("Could not create network listener: %q", err)
+	for _, entry := range entries {
+		if to.Bool(entry["admin"]) {
+			adminListener = true
+		}
+		s, err := newServices(entry)
+		if err != nil {
+			// Close whatever was already opened.
+			shutdownServices(services, 0)
+			return err
+		}
+		services = append(services, s...)
 	}
 
-	// Listener must be closed when the function exits.
-	defer listener.Close()
+	return runServices(services, timeout)
+}
+
+// runServices starts all services and blocks until a signal asks for a
+// shutdown or a service fails. SIGHUP reloads settings.yaml and all hosts
+// instead, and SIGUSR1 reopens the access logs. In-flight requests are given
+// up to timeout to finish.
+func runServices(services []service, timeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP}, reopenSignals...)...)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, len(services))
+	for _, s := range services {
+		log.Printf("Starting %s.\n", s)
+		go func(s service) {
+			if err := s.serve(); err != nil {
+				errs <- fmt.Errorf("%s failed: %v", s, err)
+			}
+		}(s)
+	}
 
-	// Attempt to start a server.
-	switch serverType {
-	case "fastcgi":
-		if err == nil {
-			log.Printf("Starting FastCGI server. Listening at %s.\n", address)
-			fcgi.Serve(listener, &server{})
-		} else {
-			return fmt.Errorf("Failed to start FastCGI server: %q", err)
+	var failure error
+	for failure == nil {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				log.Printf("Received %s, reloading settings.\n", sig)
+				if err := reloadSettings(); err != nil {
+					log.Printf("Error reloading settings file %s: %q\n", *flagSettings, err)
+				}
+				continue
+			}
+			if isReopenSignal(sig) {
+				log.Printf("Received %s, reopening access logs.\n", sig)
+				accesslog.Reopen()
+				continue
+			}
+			log.Printf("Received %s, shutting down.\n", sig)
+			return shutdownServices(services, timeout)
+		case failure = <-errs:
+			log.Printf("%v, shutting down.\n", failure)
 		}
-	case "standalone":
-		if err == nil {
-			log.Printf("Starting HTTP server. Listening at %s.\n", address)
-			http.Serve(listener, &server{})
-		} else {
-			return fmt.Errorf("Failed to start HTTP server: %q", err)
+	}
+
+	shutdownServices(services, timeout)
+	return failure
+}
+
+// isReopenSignal returns true for signals that reopen the access logs.
+func isReopenSignal(sig os.Signal) bool {
+	for _, s := range reopenSignals {
+		if s == sig {
+			return true
 		}
-	default:
-		return fmt.Errorf("Unknown server type: %s", serverType)
+	}
+	return false
+}
+
+// shutdownServices shuts all services down in parallel, waiting at most
+// timeout for in-flight requests.
+func shutdownServices(services []service, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errs := make(chan error, len(services))
+	for _, s := range services {
+		go func(s service) {
+			if err := s.shutdown(ctx); err != nil {
+				errs <- fmt.Errorf("%s did not shut down cleanly: %v", s, err)
+				return
+			}
+			errs <- nil
+		}(s)
 	}
 
-	return nil
+	var failure error
+	for range services {
+		if err := <-errs; err != nil {
+			log.Println(err)
+			failure = err
+		}
+	}
+	if failure == nil {
+		log.Printf("Shutdown complete.\n")
+	}
+	return failure
 }
 
 func init() {
@@ -0,0 +1,193 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// blockingService returns an HTTP service on a local port whose requests
+// wait for release to be closed, and the URL it serves.
+func blockingService(t *testing.T, started chan<- bool, release <-chan bool) (service, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/block" {
+			started <- true
+			<-release
+		}
+		fmt.Fprint(w, "done")
+	})}
+	return &httpService{listener: listener, server: srv}, "http://" + listener.Addr().String()
+}
+
+// waitServing waits until a service answers requests for url.
+func waitServing(t *testing.T, url string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is not served", url)
+}
+
+func TestRunServicesDrains(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	s, url := blockingService(t, started, release)
+
+	done := make(chan error, 1)
+	go func() { done <- runServices([]service{s}, 5*time.Second) }()
+	waitServing(t, url)
+
+	response := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url + "/block")
+		if err != nil {
+			response <- 0
+			return
+		}
+		resp.Body.Close()
+		response <- resp.StatusCode
+	}()
+	<-started
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-done:
+		t.Fatalf("returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// New connections are refused while draining.
+	if resp, err := http.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("accepted a request while shutting down")
+	}
+
+	close(release)
+	if code := <-response; code != http.StatusOK {
+		t.Errorf("in-flight request = %d, want 200", code)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("runServices = %v, want a clean shutdown", err)
+	}
+}
+
+func TestShutdownServicesTimeout(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	defer close(release)
+	s, url := blockingService(t, started, release)
+	go s.serve()
+	waitServing(t, url)
+
+	go http.Get(url + "/block")
+	<-started
+
+	start := time.Now()
+	err := shutdownServices([]service{s}, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("shutdownServices = %v, want the deadline error", err)
+	}
+	// Serving fills in the TLS config of plain HTTP servers.
+	if want := "HTTP server at " + s.(*httpService).listener.Addr().String(); err != nil && !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("shutdownServices = %v, want it to name the %s", err, want)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %v with a 50ms timeout", elapsed)
+	}
+}
+
+func TestRunServicesFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A closed listener makes the service fail right away.
+	listener.Close()
+	s := &httpService{listener: listener, server: &http.Server{}}
+	if err := runServices([]service{s}, time.Second); err == nil {
+		t.Error("runServices = nil for a failed service, want its error")
+	}
+}
+
+func TestRunServicesReload(t *testing.T) {
+	site := writeTestSite(t, map[string]string{"content/index.md": "# Home\n"})
+	loadTestSettings(t, fmt.Sprintf("hosts:\n  default: %q\n", site))
+
+	hostsLock.RLock()
+	before, loaded := hosts[defaultHost], settingsLoaded
+	hostsLock.RUnlock()
+
+	started, release := make(chan bool), make(chan bool)
+	defer close(release)
+	s, url := blockingService(t, started, release)
+	done := make(chan error, 1)
+	go func() { done <- runServices([]service{s}, time.Second) }()
+	waitServing(t, url)
+
+	// SIGHUP reloads every host, and keeps serving.
+	if err := ioutil.WriteFile(filepath.Join(site, "site.yaml"), []byte("title: Reloaded\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	for i := 0; ; i++ {
+		hostsLock.RLock()
+		after, reloaded := hosts[defaultHost], settingsLoaded
+		hostsLock.RUnlock()
+		if reloaded.After(loaded) {
+			if after == before {
+				t.Error("hosts were not loaded again")
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatal("settings were not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("runServices returned after SIGHUP: %v", err)
+	default:
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err := <-done; err != nil {
+		t.Errorf("runServices = %v, want a clean shutdown", err)
+	}
+}
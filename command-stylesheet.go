@@ -0,0 +1,61 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/lnxjedi/cli"
+	"github.com/lnxjedi/luminos/host"
+)
+
+var flagOutput = flag.String("o", "", "File to write to instead of the standard output")
+
+// stylesheetCommand is the structure that provides instructions for the
+// "luminos stylesheet" subcommand.
+type stylesheetCommand struct {
+}
+
+// Execute writes the stylesheet for the code blocks highlighted in pages.
+func (c *stylesheetCommand) Execute() error {
+	css := host.Stylesheet()
+	if *flagOutput == "" {
+		fmt.Print(css)
+		return nil
+	}
+	if err := ioutil.WriteFile(*flagOutput, []byte(css), 0644); err != nil {
+		return fmt.Errorf("error while writing %s: %q", *flagOutput, err)
+	}
+	return nil
+}
+
+func init() {
+	// Describing the "stylesheet" subcommand.
+	cli.Register("stylesheet", cli.Entry{
+		Name:        "stylesheet",
+		Description: "Writes the stylesheet for highlighted code.",
+		Arguments:   []string{"o"},
+		Command:     &stylesheetCommand{},
+	})
+}
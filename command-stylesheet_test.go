@@ -0,0 +1,49 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/lnxjedi/luminos/host"
+)
+
+func TestStylesheetCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "syntax.css")
+	saved := *flagOutput
+	*flagOutput = file
+	defer func() { *flagOutput = saved }()
+
+	if err := (&stylesheetCommand{}).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if css, err := ioutil.ReadFile(file); err != nil || string(css) != host.Stylesheet() {
+		t.Errorf("wrote %q, %v; want the stylesheet", css, err)
+	}
+
+	*flagOutput = filepath.Join(file, "missing", "syntax.css")
+	if err := (&stylesheetCommand{}).Execute(); err == nil {
+		t.Error("writing to a missing directory succeeded")
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/lnxjedi/luminos/host"
+	"github.com/lnxjedi/to"
+)
+
+// Default values for the "compression" settings.
+const (
+	envCompressionMinSize = 1024
+	envCompressionLevel   = gzip.DefaultCompression
+)
+
+// Content types compressed when the "types" list is missing.
+var envCompressionTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// compressionConfig holds the "compression" section of settings.yaml.
+type compressionConfig struct {
+	minSize int
+	types   map[string]bool
+	writers sync.Pool
+}
+
+// Response compression; nil when disabled.
+var compression *compressionConfig
+
+// loadCompression reads the "compression" section of settings.yaml. It
+// returns nil when the section is missing.
+func loadCompression(conf map[string]interface{}) (*compressionConfig, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	c := &compressionConfig{minSize: envCompressionMinSize, types: map[string]bool{}}
+
+	if v, ok := conf["min_size"]; ok {
+		if c.minSize = int(to.Int64(v)); c.minSize < 0 {
+			return nil, fmt.Errorf("invalid compression min_size: %v", v)
+		}
+	}
+
+	level := envCompressionLevel
+	if v, ok := conf["level"]; ok {
+		level = int(to.Int64(v))
+	}
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		return nil, fmt.Errorf("invalid compression level: %d", level)
+	}
+
+	types := envCompressionTypes
+	if list, ok := conf["types"].([]interface{}); ok {
+		types = nil
+		for _, t := range list {
+			types = append(types, to.String(t))
+		}
+	}
+	for _, t := range types {
+		c.types[strings.ToLower(t)] = true
+	}
+
+	c.writers.New = func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, level)
+		return gz
+	}
+
+	return c, nil
+}
+
+// compressible returns true when responses of contentType may be compressed.
+func (c *compressionConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return c.types[mediaType]
+}
+
+// wrap returns a writer that compresses the response when the client accepts
+// gzip, along with a function that must be called once the response is
+// complete.
+func (c *compressionConfig) wrap(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, func()) {
+	if c == nil || req.Method == http.MethodHead || !host.AcceptsEncoding(req, "gzip") {
+		return w, func() {}
+	}
+	cw := &compressWriter{ResponseWriter: w, config: c, status: http.StatusOK}
+	return cw, cw.close
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// the response is worth compressing: it must have an allowed content type,
+// a 200 status, no encoding of its own and at least minSize bytes.
+type compressWriter struct {
+	http.ResponseWriter
+	config *compressionConfig
+	status int
+	// Start of the body, held back until a decision is made.
+	buf []byte
+	// True once the headers were sent.
+	decided bool
+	// Compressor; nil when the response is passed through.
+	gz *gzip.Writer
+}
+
+// WriteHeader records the status; the headers are sent later.
+func (cw *compressWriter) WriteHeader(status int) {
+	if !cw.decided {
+		cw.status = status
+	}
+}
+
+// Write compresses or passes through data, holding back the first bytes
+// until a decision can be made.
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, data...)
+		if len(cw.buf) < cw.config.minSize && !cw.ineligible() {
+			return len(data), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// ineligible returns true when the response can't be compressed whatever
+// its size.
+func (cw *compressWriter) ineligible() bool {
+	header := cw.Header()
+	if cw.status != http.StatusOK || header.Get("Content-Encoding") != "" {
+		return true
+	}
+	if ct := header.Get("Content-Type"); ct != "" && !cw.config.compressible(ct) {
+		return true
+	}
+	return false
+}
+
+// decide sends the headers and the held back data, compressing them when
+// the response is eligible and, if large is false, no smaller than minSize.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	header := cw.Header()
+
+	if cw.status == http.StatusOK && header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	eligible := !cw.ineligible()
+	if eligible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if eligible && (large || len(cw.buf) >= cw.config.minSize) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		// The compressed body differs from the original one.
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.gz = cw.config.writers.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends whatever was written so far to the client.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands over the connection when the underlying writer allows it.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("connection can't be hijacked")
+}
+
+// close finishes the response.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.gz != nil {
+		cw.gz.Close()
+		cw.gz.Reset(nil)
+		cw.config.writers.Put(cw.gz)
+		cw.gz = nil
+	}
+}
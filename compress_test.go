@@ -0,0 +1,166 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoadCompression(t *testing.T) {
+	if c, err := loadCompression(nil); c != nil || err != nil {
+		t.Errorf("loadCompression(nil) = %v, %v, want nil, nil", c, err)
+	}
+
+	c, err := loadCompression(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.minSize != envCompressionMinSize || !c.compressible("text/html; charset=utf-8") || c.compressible("image/png") {
+		t.Errorf("unexpected defaults: %d bytes, %v", c.minSize, c.types)
+	}
+
+	c, err = loadCompression(map[string]interface{}{"min_size": 10, "types": []interface{}{"Text/CSV"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.minSize != 10 || !c.compressible("text/csv") || c.compressible("text/html") {
+		t.Errorf("unexpected settings: %d bytes, %v", c.minSize, c.types)
+	}
+
+	for _, conf := range []map[string]interface{}{
+		{"min_size": -1},
+		{"level": 12},
+	} {
+		if _, err := loadCompression(conf); err == nil {
+			t.Errorf("loadCompression(%v) succeeded", conf)
+		}
+	}
+}
+
+func TestCompressWriter(t *testing.T) {
+	c, err := loadCompression(map[string]interface{}{"min_size": 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("<p>Hello</p>\n", 10)
+
+	tests := []struct {
+		name       string
+		method     string
+		accept     string
+		status     int
+		header     map[string]string
+		body       []string
+		compressed bool
+		vary       bool
+	}{
+		{name: "large page", accept: "gzip", body: []string{large}, compressed: true, vary: true},
+		{name: "small writes", accept: "gzip", body: []string{"<p>", "Hello", "</p>", strings.Repeat(" ", 16)}, compressed: true, vary: true},
+		{name: "small page", accept: "gzip", body: []string{"<p>Hi</p>"}, vary: true},
+		{name: "no gzip", accept: "br", body: []string{large}},
+		{name: "head", method: http.MethodHead, accept: "gzip", body: []string{large}},
+		{name: "not found", accept: "gzip", status: http.StatusNotFound, body: []string{large}},
+		{name: "image", accept: "gzip", header: map[string]string{"Content-Type": "image/png"}, body: []string{large}},
+		{name: "encoded", accept: "gzip", header: map[string]string{"Content-Encoding": "br"}, body: []string{large}},
+	}
+	for _, test := range tests {
+		method := test.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+		rec := httptest.NewRecorder()
+
+		w, done := c.wrap(rec, req)
+		for k, v := range test.header {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Content-Length", "1000")
+		w.Header().Set("ETag", `"abc"`)
+		if test.status != 0 {
+			w.WriteHeader(test.status)
+		}
+		for _, b := range test.body {
+			w.Write([]byte(b))
+		}
+		done()
+
+		want := strings.Join(test.body, "")
+		body := rec.Body.String()
+		if test.compressed {
+			if rec.Header().Get("Content-Encoding") != "gzip" {
+				t.Errorf("%s: not compressed", test.name)
+				continue
+			}
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+			buf, _ := ioutil.ReadAll(gz)
+			body = string(buf)
+			if rec.Header().Get("Content-Length") != "" || rec.Header().Get("ETag") != `W/"abc"` {
+				t.Errorf("%s: Content-Length %q and ETag %q kept", test.name, rec.Header().Get("Content-Length"), rec.Header().Get("ETag"))
+			}
+		} else if enc := rec.Header().Get("Content-Encoding"); enc == "gzip" {
+			t.Errorf("%s: compressed", test.name)
+		}
+		if body != want {
+			t.Errorf("%s: body = %q, want %q", test.name, body, want)
+		}
+		if vary := rec.Header().Get("Vary") == "Accept-Encoding"; vary != test.vary {
+			t.Errorf("%s: Vary set = %v, want %v", test.name, vary, test.vary)
+		}
+	}
+}
+
+func TestServeCompressed(t *testing.T) {
+	page := strings.Repeat("Some words worth compressing.\n\n", 100)
+	site := writeTestSite(t, map[string]string{"content/index.md": page})
+	loadTestSettings(t, fmt.Sprintf("compression:\n  level: 9\nhosts:\n  default: %q\n", site))
+
+	plain := serve(httptest.NewRequest(http.MethodGet, "/", nil))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := serve(req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("page not compressed: %v", w.Header())
+	}
+	if w.Body.Len() >= plain.Body.Len() {
+		t.Errorf("compressed page is %d bytes, the page itself %d", w.Body.Len(), plain.Body.Len())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadAll(gz); string(buf) != plain.Body.String() {
+		t.Errorf("decompressed page = %q, want %q", buf, plain.Body.String())
+	}
+}
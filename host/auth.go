@@ -0,0 +1,437 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/to"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default realm sent with authentication challenges.
+const envAuthRealm = "Restricted"
+
+// Access lists who may read a path or a page. With no users and groups any
+// valid user may.
+type Access struct {
+	// Public pages need no authentication, even below a protected path.
+	Public bool
+	Users  []string
+	Groups []string
+}
+
+// allows returns true if a user, member of groups, has access.
+func (a *Access) allows(user string, groups []string) bool {
+	if len(a.Users) == 0 && len(a.Groups) == 0 {
+		return true
+	}
+	for _, u := range a.Users {
+		if u == user {
+			return true
+		}
+	}
+	for _, g := range a.Groups {
+		for _, member := range groups {
+			if g == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// protectedPath is an entry of the "protect" list in site.yaml.
+type protectedPath struct {
+	prefix string
+	access Access
+}
+
+// authConfig holds the "auth" section of site.yaml.
+type authConfig struct {
+	realm     string
+	passwords *credentialFile
+	groups    *credentialFile
+	protect   []protectedPath
+}
+
+// credentialFile is an htpasswd or htgroup file, read again whenever it
+// changes.
+type credentialFile struct {
+	path string
+	// Parses the lines of the file.
+	parse func(file string, lines []string) map[string][]string
+
+	sync.Mutex
+	modified time.Time
+	entries  map[string][]string
+	// Hashes of passwords already checked against bcrypt hashes, since
+	// bcrypt is slow on purpose.
+	verified map[string][sha256.Size]byte
+}
+
+// load returns the entries of the file, reading it again when it changed.
+func (f *credentialFile) load() map[string][]string {
+	f.Lock()
+	defer f.Unlock()
+
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		log.Printf("Could not read %s: %v\n", f.path, err)
+		return f.entries
+	}
+	if f.entries != nil && stat.ModTime().Equal(f.modified) {
+		return f.entries
+	}
+
+	buf, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		log.Printf("Could not read %s: %v\n", f.path, err)
+		return f.entries
+	}
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(buf)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	f.entries = f.parse(f.path, lines)
+	f.modified = stat.ModTime()
+	f.verified = map[string][sha256.Size]byte{}
+	return f.entries
+}
+
+// parseHtpasswd reads "user:hash" lines; only bcrypt hashes are supported.
+func parseHtpasswd(file string, lines []string) map[string][]string {
+	entries := map[string][]string{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], "$2") {
+			log.Printf("%s: ignoring entry without a bcrypt hash: %s\n", file, parts[0])
+			continue
+		}
+		entries[parts[0]] = []string{parts[1]}
+	}
+	return entries
+}
+
+// parseHtgroup reads "group: user user..." lines and returns the groups of
+// every user.
+func parseHtgroup(file string, lines []string) map[string][]string {
+	entries := map[string][]string{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			log.Printf("%s: ignoring invalid line: %s\n", file, line)
+			continue
+		}
+		group := strings.TrimSpace(parts[0])
+		for _, user := range strings.Fields(parts[1]) {
+			entries[user] = append(entries[user], group)
+		}
+	}
+	return entries
+}
+
+// verify checks a user's password.
+func (f *credentialFile) verify(user, password string) bool {
+	entries := f.load()
+	hash, ok := entries[user]
+	if !ok {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(hash[0] + "\x00" + password))
+	f.Lock()
+	known, ok := f.verified[user]
+	f.Unlock()
+	if ok && subtle.ConstantTimeCompare(known[:], sum[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash[0]), []byte(password)) != nil {
+		return false
+	}
+	f.Lock()
+	if f.verified != nil {
+		f.verified[user] = sum
+	}
+	f.Unlock()
+	return true
+}
+
+// groupsOf returns the groups a user belongs to.
+func (a *authConfig) groupsOf(user string) []string {
+	if a.groups == nil {
+		return nil
+	}
+	return a.groups.load()[user]
+}
+
+// loadAccess reads users and groups lists into an Access.
+func loadAccess(conf map[string]interface{}) Access {
+	var a Access
+	if list, ok := conf["users"].([]interface{}); ok {
+		for _, u := range list {
+			a.Users = append(a.Users, to.String(u))
+		}
+	}
+	if list, ok := conf["groups"].([]interface{}); ok {
+		for _, g := range list {
+			a.Groups = append(a.Groups, to.String(g))
+		}
+	}
+	return a
+}
+
+// loadAuth reads the "auth" section of site.yaml. Relative file names are
+// relative to the site directory.
+func loadAuth(root string, conf interface{}) (*authConfig, error) {
+	m, ok := conf.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	a := &authConfig{realm: to.String(m["realm"])}
+	if a.realm == "" {
+		a.realm = envAuthRealm
+	}
+
+	file := to.String(m["htpasswd"])
+	if file == "" {
+		return nil, fmt.Errorf("auth requires an htpasswd file")
+	}
+	if !path.IsAbs(file) {
+		file = path.Join(root, file)
+	}
+	if _, err := os.Stat(file); err != nil {
+		return nil, fmt.Errorf("auth: %v", err)
+	}
+	a.passwords = &credentialFile{path: file, parse: parseHtpasswd}
+
+	if file = to.String(m["htgroup"]); file != "" {
+		if !path.IsAbs(file) {
+			file = path.Join(root, file)
+		}
+		if _, err := os.Stat(file); err != nil {
+			return nil, fmt.Errorf("auth: %v", err)
+		}
+		a.groups = &credentialFile{path: file, parse: parseHtgroup}
+	}
+
+	if list, ok := m["protect"].([]interface{}); ok {
+		for i, item := range list {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("auth: protected path %d is not a map", i+1)
+			}
+			prefix := to.String(entry["path"])
+			if prefix == "" {
+				return nil, fmt.Errorf("auth: protected path %d has no path", i+1)
+			}
+			a.protect = append(a.protect, protectedPath{prefix: cleanPath(prefix), access: loadAccess(entry)})
+		}
+	}
+
+	// The longest matching prefix wins.
+	sort.SliceStable(a.protect, func(i, j int) bool {
+		return len(a.protect[i].prefix) > len(a.protect[j].prefix)
+	})
+
+	return a, nil
+}
+
+// required returns the access rule for reqpath, the request path below the
+// mount path, or nil when it isn't protected. A page's own Access takes
+// precedence over the protected paths.
+func (a *authConfig) required(reqpath string, page *Access) *Access {
+	if page != nil {
+		if page.Public {
+			return nil
+		}
+		return page
+	}
+	p := cleanPath(reqpath)
+	for i := range a.protect {
+		prefix := a.protect[i].prefix
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return &a.protect[i].access
+		}
+	}
+	return nil
+}
+
+// identify sets the user of the request from valid basic auth credentials,
+// unless a trusted proxy already provided one. Invalid credentials are
+// ignored here; authorize rejects them where access is restricted.
+func (ctx *Context) identify() {
+	ctx.RLock()
+	a := ctx.auth
+	ctx.RUnlock()
+
+	if ctx.User == "" && a != nil {
+		if user, password, ok := ctx.Request.BasicAuth(); ok && a.passwords.verify(user, password) {
+			ctx.User = user
+			ctx.Groups = a.groupsOf(user)
+		}
+	}
+
+	// Menus and search results depend on the user.
+	if ctx.User != "" {
+		ctx.private = true
+	}
+}
+
+// authorize checks the user of the request against the access rule for
+// reqpath and page. It sends a 401 or 403 and returns false when the
+// request must not be served.
+func (ctx *Context) authorize(reqpath string, page *Access) bool {
+	ctx.RLock()
+	a := ctx.auth
+	ctx.RUnlock()
+
+	access := page
+	if a != nil {
+		access = a.required(reqpath, page)
+	} else if page != nil && page.Public {
+		access = nil
+	}
+	if access == nil {
+		return true
+	}
+
+	if ctx.User == "" {
+		if a == nil {
+			// Without an htpasswd file users only come from a trusted proxy.
+			ctx.serveError(http.StatusForbidden, errors.New("page requires a user but none was provided"))
+			return false
+		}
+		ctx.Response.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.realm))
+		ctx.serveError(http.StatusUnauthorized, nil)
+		return false
+	}
+
+	if !access.allows(ctx.User, ctx.Groups) {
+		ctx.serveError(http.StatusForbidden, fmt.Errorf("user %s is not allowed", ctx.User))
+		return false
+	}
+
+	ctx.private = true
+	return true
+}
+
+// CanSee returns true if the user of the request may read a content file or
+// directory; pages the user can't read are left out of menus and search
+// results.
+func (ctx *Context) CanSee(file string) bool {
+	ctx.RLock()
+	a := ctx.auth
+	ctx.RUnlock()
+
+	root, err := ctx.GetContentPath()
+	if err != nil {
+		return true
+	}
+	abs, err := filepath.Abs(file)
+	if err == nil {
+		root, err = filepath.Abs(root)
+	}
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return true
+	}
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return true
+	}
+	dir := path.Dir(file)
+	if stat.IsDir() {
+		dir = file
+		file, _ = guessFile(path.Join(file, "index"), true)
+	}
+	dfile, _ := guessFile(path.Join(dir, "_defaults"), true)
+
+	access := pageAccess(file, dfile)
+	if a != nil {
+		access = a.required(pageURL(filepath.ToSlash(rel)), access)
+	} else if access != nil && access.Public {
+		access = nil
+	}
+	if access == nil {
+		return true
+	}
+	return ctx.User != "" && access.allows(ctx.User, ctx.Groups)
+}
+
+// fileAccess returns the Access of a file served as is, so that pages can't
+// be read by requesting their files: the one in the frontmatter of file, if
+// it's a content file, or else the one of the _defaults file of the content
+// directory reqpath is in.
+func (ctx *Context) fileAccess(reqpath, file string) *Access {
+	docroot, err := ctx.GetContentPath()
+	if err != nil {
+		return pageAccess(file)
+	}
+	dfile, _ := guessFile(path.Join(docroot, path.Dir("/"+reqpath), "_defaults"), true)
+	return pageAccess(file, dfile)
+}
+
+// pageAccess returns the Access from the frontmatter of a content file, or
+// else of its directory's _defaults file.
+func pageAccess(files ...string) *Access {
+	for i, file := range files {
+		if file == "" || strings.HasSuffix(file, ".tpl") {
+			continue
+		}
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var fm frontMatter
+		parseFrontMatter(file, buf, i > 0, &fm)
+		if fm.Access != nil {
+			return fm.Access
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAccessByFileName(t *testing.T) {
+	host := newTestHost(t, "default", map[string]string{
+		"content/index.md":          "Home\n",
+		"content/secret/page.html":  "---\n#luminos\nAccess: { Groups: [\"ops\"] }\n---\nsecret page\n",
+		"content/docs/_defaults.md": "---\nAccess: { Groups: [\"ops\"] }\n---\n",
+		"content/docs/index.md":     "docs index\n",
+		"content/docs/notes.txt":    "secret notes\n",
+		"webroot/docs/report.pdf":   "secret report\n",
+	})
+
+	for _, target := range []string{
+		"/secret/page",
+		"/secret/page.html",
+		"/docs",
+		"/docs/notes.txt",
+		"/docs/report.pdf",
+	} {
+		w := get(host, target)
+		if w.Code == http.StatusOK {
+			t.Errorf("%s: got %d, want the page to be protected", target, w.Code)
+		}
+		if body := w.Body.String(); strings.Contains(body, "secret") {
+			t.Errorf("%s: protected content was served: %q", target, body)
+		}
+	}
+
+	if w := get(host, "/"); w.Code != http.StatusOK {
+		t.Errorf("/: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/to"
+)
+
+// Default memory cap of a host's render cache, in megabytes.
+const envRenderCacheSize = 32
+
+// cachedPage is a rendered page kept in memory.
+type cachedPage struct {
+	key      string
+	body     []byte
+	etag     string
+	modified time.Time
+	// Access from the page's frontmatter.
+	access *Access
+	// CSP nonce the page was rendered with, replaced on every response.
+	nonce string
+}
+
+// renderCache keeps rendered pages in memory up to a total size, evicting
+// the least recently used pages first.
+type renderCache struct {
+	sync.Mutex
+	// Maximum total size of the cached pages in bytes; 0 disables the cache.
+	max  int
+	size int
+	// Incremented on every invalidation, so that pages rendered from older
+	// files are not stored.
+	generation uint64
+	pages      map[string]*list.Element
+	lru        *list.List
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{pages: map[string]*list.Element{}, lru: list.New()}
+}
+
+// setLimit changes the memory cap, evicting pages as needed.
+func (c *renderCache) setLimit(max int) {
+	c.Lock()
+	defer c.Unlock()
+	c.max = max
+	c.evict()
+}
+
+// get returns a cached page and marks it as recently used.
+func (c *renderCache) get(key string) (*cachedPage, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.pages[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cachedPage), true
+	}
+	return nil, false
+}
+
+// current returns the generation to pass to put once a page is rendered.
+func (c *renderCache) current() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.generation
+}
+
+// put stores a page rendered during the given generation.
+func (c *renderCache) put(generation uint64, p *cachedPage) {
+	c.Lock()
+	defer c.Unlock()
+	if generation != c.generation || len(p.body) > c.max {
+		return
+	}
+	if e, ok := c.pages[p.key]; ok {
+		c.size -= len(e.Value.(*cachedPage).body)
+		c.lru.Remove(e)
+	}
+	c.pages[p.key] = c.lru.PushFront(p)
+	c.size += len(p.body)
+	c.evict()
+}
+
+// evict removes the least recently used pages until the cache fits.
+func (c *renderCache) evict() {
+	for c.size > c.max && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		p := c.lru.Remove(e).(*cachedPage)
+		delete(c.pages, p.key)
+		c.size -= len(p.body)
+	}
+}
+
+// invalidate empties the cache.
+func (c *renderCache) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	c.pages = map[string]*list.Element{}
+	c.lru.Init()
+	c.size = 0
+}
+
+// loadRenderCacheSize reads the "render_cache" section of site.yaml and
+// returns the memory cap in bytes.
+func loadRenderCacheSize(conf interface{}) (int, error) {
+	size := int64(envRenderCacheSize)
+	if m, ok := conf.(map[string]interface{}); ok {
+		if v, ok := m["max_size"]; ok {
+			size = to.Int64(v)
+		}
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("invalid render_cache max_size: %d", size)
+	}
+	return int(size) << 20, nil
+}
+
+// cacheKey identifies a rendered page; anything that may change the output
+// of the templates is part of it, including the user since menus only list
+// what the user can see.
+func (ctx *Context) cacheKey() string {
+	return strings.Join([]string{
+		ctx.Scheme,
+		ctx.Request.Host,
+		ctx.Prefix,
+		ctx.MountPath,
+		ctx.Request.URL.Path,
+		ctx.Request.URL.RawQuery,
+		ctx.User,
+		strings.Join(ctx.Groups, ","),
+	}, "\x00")
+}
+
+// watchContent adds every directory of the content tree to the host's
+// watcher, so that changes to content invalidate the render cache.
+func (host *Host) watchContent() {
+	cpath, err := host.GetContentPath()
+	if err != nil {
+		return
+	}
+	if cpath, err = filepath.Abs(cpath); err != nil {
+		return
+	}
+
+	host.Lock()
+	host.contentRoot = cpath
+	host.Unlock()
+
+	host.watchTree(cpath)
+}
+
+// watchTree adds a directory and all directories below it to the watcher.
+func (host *Host) watchTree(root string) {
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if err := host.Watcher.Add(p); err != nil {
+				log.Printf("%s: Could not watch %s: %v\n", host.Name, p, err)
+			}
+		}
+		return nil
+	})
+}
+
+// inContent returns true if a file is part of the content tree.
+func (host *Host) inContent(file string) bool {
+	host.RLock()
+	root := host.contentRoot
+	host.RUnlock()
+	return root != "" && (file == root || strings.HasPrefix(file, root+string(os.PathSeparator)))
+}
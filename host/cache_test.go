@@ -0,0 +1,145 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cachedKeys returns the keys of a cache, most recently used first.
+func cachedKeys(c *renderCache) string {
+	c.Lock()
+	defer c.Unlock()
+	var keys []string
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*cachedPage).key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestRenderCacheEviction(t *testing.T) {
+	c := newRenderCache()
+	c.setLimit(10)
+	put := func(key string, size int) {
+		c.put(c.current(), &cachedPage{key: key, body: make([]byte, size)})
+	}
+
+	put("a", 4)
+	put("b", 4)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a isn't cached")
+	}
+	put("c", 4)
+	if got := cachedKeys(c); got != "c,a" {
+		t.Errorf("after evicting: %q, want \"c,a\"", got)
+	}
+
+	// Replacing a page doesn't count it twice.
+	put("a", 6)
+	if got := cachedKeys(c); got != "a,c" || c.size != 10 {
+		t.Errorf("after replacing: %q of %d bytes, want \"a,c\" of 10", got, c.size)
+	}
+
+	put("huge", 11)
+	if _, ok := c.get("huge"); ok {
+		t.Error("a page larger than the cache was stored")
+	}
+
+	c.setLimit(6)
+	if got := cachedKeys(c); got != "a" {
+		t.Errorf("after shrinking: %q, want \"a\"", got)
+	}
+	c.setLimit(0)
+	if got := cachedKeys(c); got != "" {
+		t.Errorf("after disabling: %q, want nothing", got)
+	}
+}
+
+func TestRenderCacheGeneration(t *testing.T) {
+	c := newRenderCache()
+	c.setLimit(100)
+	c.put(c.current(), &cachedPage{key: "a", body: []byte("old")})
+
+	// A page rendered before an invalidation may come from older files.
+	generation := c.current()
+	c.invalidate()
+	c.put(generation, &cachedPage{key: "b", body: []byte("stale")})
+	if got := cachedKeys(c); got != "" {
+		t.Errorf("after invalidating: %q, want nothing", got)
+	}
+	c.put(c.current(), &cachedPage{key: "b", body: []byte("new")})
+	if got := cachedKeys(c); got != "b" {
+		t.Errorf("after rendering again: %q, want \"b\"", got)
+	}
+}
+
+func TestLoadRenderCacheSize(t *testing.T) {
+	tests := []struct {
+		conf interface{}
+		size int
+		err  bool
+	}{
+		{nil, envRenderCacheSize << 20, false},
+		{map[string]interface{}{}, envRenderCacheSize << 20, false},
+		{map[string]interface{}{"max_size": 2}, 2 << 20, false},
+		{map[string]interface{}{"max_size": 0}, 0, false},
+		{map[string]interface{}{"max_size": -1}, 0, true},
+	}
+	for _, test := range tests {
+		size, err := loadRenderCacheSize(test.conf)
+		if size != test.size || (err != nil) != test.err {
+			t.Errorf("loadRenderCacheSize(%v) = %d, %v", test.conf, size, err)
+		}
+	}
+}
+
+func TestRenderCacheContentChange(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"content/index.md": "# Old\n",
+	})
+	if body := get(host, "/").Body.String(); !strings.Contains(body, "Old") {
+		t.Fatalf("home page = %q", body)
+	}
+	if got := cachedKeys(host.cache); got == "" {
+		t.Fatal("the home page wasn't cached")
+	}
+
+	content, _ := host.GetContentPath()
+	if err := ioutil.WriteFile(filepath.Join(content, "index.md"), []byte("# New\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		body := get(host, "/").Body.String()
+		if strings.Contains(body, "New") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("home page = %q after changing it", body)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
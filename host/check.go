@@ -0,0 +1,168 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lnxjedi/to"
+)
+
+// Problem is something wrong with a site, found by Check.
+type Problem struct {
+	// File the problem was found in; empty when it concerns the whole site.
+	File string
+	// Description of the problem.
+	Message string
+	// True for problems that don't keep the site from being served.
+	Warning bool
+}
+
+func (p Problem) String() string {
+	if p.File == "" {
+		return p.Message
+	}
+	return p.File + ": " + p.Message
+}
+
+// Check looks for mistakes that loading the host doesn't catch or only
+// logs: missing directories, templates that don't parse, and content files
+// with invalid frontmatter or naming a template that doesn't exist. The
+// host must have been created by New.
+func (host *Host) Check() []Problem {
+	var problems []Problem
+
+	host.RLock()
+	settings := host.Settings
+	group := host.TemplateGroup
+	tplroot := host.TemplateRoot
+	md := host.markdown
+	host.RUnlock()
+
+	// Directories named in site.yaml must exist; the webroot is optional
+	// unless it's named.
+	for _, key := range []string{"markdown", "templates", "webroot"} {
+		dir := to.String(settings.Get("content", key))
+		if dir == "" {
+			continue
+		}
+		full := path.Join(host.DocumentRoot, dir)
+		if stat, err := os.Stat(full); err != nil {
+			problems = append(problems, Problem{File: settingsFile, Message: fmt.Sprintf("content.%s: %v", key, err)})
+		} else if !stat.IsDir() {
+			problems = append(problems, Problem{File: settingsFile, Message: fmt.Sprintf("content.%s: %s is not a directory", key, full)})
+		}
+	}
+
+	// The host only logs templates that fail to parse, and then goes on
+	// without them.
+	files, _ := filepath.Glob(path.Join(tplroot, "*.tpl"))
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err == nil {
+			_, err = template.New(path.Base(file)).Funcs(host.funcMap).Parse(string(buf))
+		}
+		if err != nil {
+			problems = append(problems, Problem{File: file, Message: err.Error()})
+		}
+	}
+
+	if group != nil && group.Lookup("search.tpl") == nil {
+		problems = append(problems, Problem{File: tplroot, Message: "search.tpl could not be found, searches will fail", Warning: true})
+	}
+
+	cpath, err := host.GetContentPath()
+	if err != nil {
+		return append(problems, Problem{Message: err.Error()})
+	}
+
+	filepath.Walk(cpath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			problems = append(problems, Problem{File: file, Message: err.Error()})
+			return nil
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if file != cpath && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		defaults := strings.HasPrefix(name, "_defaults")
+		if strings.HasPrefix(name, ".") || !hasExtension(name) {
+			return nil
+		}
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			problems = append(problems, Problem{File: file, Message: err.Error()})
+			return nil
+		}
+		if strings.HasSuffix(name, ".tpl") {
+			if _, err := template.New("").Funcs(host.funcMap).Parse(string(buf)); err != nil {
+				problems = append(problems, Problem{File: file, Message: err.Error()})
+			}
+			return nil
+		}
+		var fm frontMatter
+		if _, err := parseFrontMatter(file, buf, defaults, &fm); err != nil {
+			problems = append(problems, Problem{File: file, Message: strings.TrimPrefix(err.Error(), "invalid frontmatter reading "+file+": ")})
+			return nil
+		}
+		if len(fm.Markdown) > 0 && md != nil {
+			// Rendering nothing is enough to have the engine look at the
+			// settings of the page.
+			if _, err := md.Render(nil, RenderOptions{Settings: fm.Markdown}); err != nil {
+				problems = append(problems, Problem{File: file, Message: err.Error()})
+			}
+		}
+		if fm.Template != "" && group.Lookup(fm.Template) == nil {
+			problems = append(problems, Problem{File: file, Message: fmt.Sprintf("template %s could not be found, index.tpl is used instead", fm.Template), Warning: true})
+		}
+		return nil
+	})
+
+	if ipath, err := host.GetIndexPath(); err == nil {
+		if _, err := os.Stat(ipath); err != nil {
+			problems = append(problems, Problem{Message: fmt.Sprintf("search index %s has not been built", ipath), Warning: true})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].File < problems[j].File })
+	return problems
+}
+
+// hasExtension returns true if a file has one of the content extensions.
+func hasExtension(name string) bool {
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
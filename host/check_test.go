@@ -0,0 +1,83 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	root := writeSite(t, map[string]string{
+		"site.yaml":                 "title: Test\ncontent:\n  webroot: \"public\"\n",
+		"templates/sidebar.tpl":     "{{ .Content ",
+		"content/index.md":          "# Home\n",
+		"content/frontmatter.md":    "---\n#luminos\nTitle: [\n---\n# Broken\n",
+		"content/template.md":       "---\n#luminos\nTemplate: missing.tpl\n---\n# Missing template\n",
+		"content/docs/_defaults.md": "---\nTemplate: index.tpl\n---\n",
+		"content/.hidden/page.md":   "---\n#luminos\nTitle: [\n---\n",
+	})
+	host, err := New("localhost", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+
+	var got []string
+	for _, p := range host.Check() {
+		s := strings.Replace(p.String(), root+string(filepath.Separator), "", -1)
+		got = append(got, fmt.Sprintf("%v %s", p.Warning, s))
+	}
+
+	// Problems come in the order of their files; site.yaml is named
+	// without its path.
+	want := []string{
+		"true search index content/search.cdb has not been built",
+		"false content/frontmatter.md: ",
+		"true content/template.md: template missing.tpl could not be found, index.tpl is used instead",
+		"true templates: search.tpl could not be found, searches will fail",
+		"false templates/sidebar.tpl: ",
+		"false site.yaml: content.webroot: stat public: ",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Check() = %q, want %d problems", got, len(want))
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("problem %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCheckClean(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"content/index.md":     "# Home\n",
+		"content/about.md":     "---\n#luminos\nTemplate: search.tpl\n---\n# About\n",
+		"content/search.cdb":   "",
+		"templates/search.tpl": "{{ .Content }}",
+	})
+	if problems := host.Check(); len(problems) > 0 {
+		t.Errorf("Check() = %v, want no problems", problems)
+	}
+}
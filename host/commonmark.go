@@ -0,0 +1,233 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/lnxjedi/to"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// commonMarkRenderer renders markdown following the CommonMark spec, with
+// the GitHub Flavored Markdown extensions by default, so that READMEs look
+// the same as on code hosts.
+type commonMarkRenderer struct {
+	conf map[string]interface{}
+	// Renderer for pages that don't change the settings of site.yaml.
+	md goldmark.Markdown
+}
+
+// Settings of the commonmark engine.
+var commonMarkSettings = map[string]bool{
+	"gfm":                       true,
+	"footnotes":                 true,
+	"html":                      true,
+	"hard_wraps":                true,
+	"definition_lists":          true,
+	"smartypants":               true,
+	"smartypants_angled_quotes": true,
+	"target_blank":              true,
+	"nofollow":                  true,
+	"noreferrer":                true,
+	"heading_id_prefix":         true,
+	"highlight":                 true,
+	"line_numbers":              true,
+}
+
+func commonMarkSetting(key string) bool {
+	return commonMarkSettings[key]
+}
+
+func newCommonMark(conf map[string]interface{}) (MarkdownRenderer, error) {
+	if err := checkMarkdownSettings(conf, commonMarkSetting); err != nil {
+		return nil, err
+	}
+	return &commonMarkRenderer{conf: conf, md: commonMark(conf)}, nil
+}
+
+// commonMark creates the parser and renderer for the settings of a site or
+// page. The GFM extensions (tables, strikethrough, task lists and
+// autolinks), footnotes and raw HTML are enabled unless "gfm", "footnotes"
+// or "html" are false; "hard_wraps" renders line breaks as <br>,
+// "definition_lists" adds PHP Markdown Extra definition lists and
+// "smartypants" turns quotes, dashes and ellipses into their typographic
+// forms, with guillemets for double quotes under
+// "smartypants_angled_quotes".
+func commonMark(conf map[string]interface{}) goldmark.Markdown {
+	var extensions []goldmark.Extender
+	if boolSetting(conf, "gfm", true) {
+		extensions = append(extensions, extension.GFM)
+	}
+	if boolSetting(conf, "footnotes", true) {
+		extensions = append(extensions, extension.Footnote)
+	}
+	if boolSetting(conf, "definition_lists", false) {
+		extensions = append(extensions, extension.DefinitionList)
+	}
+	if boolSetting(conf, "smartypants", false) {
+		var options []extension.TypographerOption
+		if boolSetting(conf, "smartypants_angled_quotes", false) {
+			options = append(options, extension.WithTypographicSubstitutions(map[extension.TypographicPunctuation]string{
+				extension.LeftDoubleQuote:  "&laquo;",
+				extension.RightDoubleQuote: "&raquo;",
+			}))
+		}
+		extensions = append(extensions, extension.NewTypographer(options...))
+	}
+
+	var htmlOptions []renderer.Option
+	if boolSetting(conf, "html", true) {
+		htmlOptions = append(htmlOptions, html.WithUnsafe())
+	}
+	if boolSetting(conf, "hard_wraps", false) {
+		htmlOptions = append(htmlOptions, html.WithHardWraps())
+	}
+
+	if boolSetting(conf, "highlight", true) {
+		code := &codeRenderer{lineNumbers: boolSetting(conf, "line_numbers", false)}
+		htmlOptions = append(htmlOptions, renderer.WithNodeRenderers(util.Prioritized(code, 100)))
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(htmlOptions...),
+	)
+}
+
+// codeRenderer highlights fenced code blocks, in place of the HTML renderer.
+type codeRenderer struct {
+	lineNumbers bool
+}
+
+func (r *codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCode)
+}
+
+func (r *codeRenderer) renderFencedCode(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	block := n.(*ast.FencedCodeBlock)
+	var info []byte
+	if block.Info != nil {
+		info = block.Info.Segment.Value(source)
+	}
+	var code bytes.Buffer
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+	_, err := w.Write(highlightCode(string(info), code.Bytes(), r.lineNumbers))
+	return ast.WalkSkipChildren, err
+}
+
+// Render parses the markdown, adjusts its links and headings and renders
+// it, after the table of contents when the page asks for one.
+func (r *commonMarkRenderer) Render(source []byte, opts RenderOptions) ([]byte, error) {
+	conf, md := r.conf, r.md
+	if len(opts.Settings) > 0 {
+		if err := checkMarkdownSettings(opts.Settings, commonMarkSetting); err != nil {
+			return nil, err
+		}
+		conf = markdownSettings(r.conf, opts.Settings)
+		md = commonMark(conf)
+	}
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	// Like blackfriday, only links leaving the site get a target and rel.
+	var rel []string
+	for _, key := range []string{"nofollow", "noreferrer"} {
+		if boolSetting(conf, key, false) {
+			rel = append(rel, key)
+		}
+	}
+	targetBlank := boolSetting(conf, "target_blank", false)
+	external := func(n ast.Node, dest []byte) {
+		if !externalLink(dest) {
+			return
+		}
+		if len(rel) > 0 {
+			n.SetAttributeString("rel", []byte(strings.Join(rel, " ")))
+		}
+		if targetBlank {
+			n.SetAttributeString("target", []byte("_blank"))
+		}
+	}
+	idPrefix := to.String(conf["heading_id_prefix"])
+
+	var toc tocList
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.Link:
+			node.Destination = prefixLink(node.Destination, opts.LinkPrefix)
+			external(node, node.Destination)
+		case *ast.AutoLink:
+			external(node, node.URL(source))
+		case *ast.Image:
+			node.Destination = prefixLink(node.Destination, opts.LinkPrefix)
+		case *ast.Heading:
+			id, _ := node.AttributeString("id")
+			idText, _ := id.([]byte)
+			if idPrefix != "" && len(idText) > 0 {
+				idText = append([]byte(idPrefix), idText...)
+				node.SetAttributeString("id", idText)
+			}
+			if opts.TOC {
+				toc.add(node.Level, template.HTMLEscapeString(string(idText)), template.HTMLEscapeString(string(node.Text(source))))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(toc.html())
+	if err := md.Renderer().Render(&out, source, doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// externalLink returns true if a link destination has a scheme or a host,
+// rather than pointing inside the site.
+func externalLink(dest []byte) bool {
+	u, err := url.Parse(string(dest))
+	return err == nil && (u.Scheme != "" || u.Host != "")
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"strings"
+	"testing"
+)
+
+// stubRenderer is a markdown engine that returns its source.
+type stubRenderer struct{}
+
+func (stubRenderer) Render(source []byte, opts RenderOptions) ([]byte, error) {
+	return source, nil
+}
+
+func TestLoadMarkdown(t *testing.T) {
+	if md, err := loadMarkdown(nil); err != nil {
+		t.Error(err)
+	} else if _, ok := md.(blackfridayRenderer); !ok {
+		t.Errorf("default engine is %T, want blackfriday", md)
+	}
+	if md, err := loadMarkdown(map[string]interface{}{"engine": "commonmark"}); err != nil {
+		t.Error(err)
+	} else if _, ok := md.(*commonMarkRenderer); !ok {
+		t.Errorf("commonmark engine is %T", md)
+	}
+
+	RegisterMarkdown("stub", func(conf map[string]interface{}) (MarkdownRenderer, error) {
+		return stubRenderer{}, nil
+	})
+	if got := renderMarkdown(t, map[string]interface{}{"engine": "stub"}, "# Title", RenderOptions{}); got != "# Title" {
+		t.Errorf("stub engine rendered %q", got)
+	}
+
+	for _, test := range []struct {
+		conf map[string]interface{}
+		err  string
+	}{
+		{map[string]interface{}{"engine": "missing"}, "unknown markdown engine missing, expected one of: blackfriday, commonmark, stub"},
+		{map[string]interface{}{"engine": "commonmark", "tables": true}, "markdown engine commonmark: unknown markdown settings: tables"},
+		{map[string]interface{}{"gfm": true, "nope": 1}, "markdown engine blackfriday: unknown markdown settings: gfm, nope"},
+	} {
+		if _, err := loadMarkdown(test.conf); err == nil || err.Error() != test.err {
+			t.Errorf("loadMarkdown(%v) = %v, want %q", test.conf, err, test.err)
+		}
+	}
+}
+
+func TestCommonMark(t *testing.T) {
+	commonmark := map[string]interface{}{"engine": "commonmark"}
+	tests := []struct {
+		name   string
+		conf   map[string]interface{}
+		source string
+		want   string
+	}{
+		{"table", nil, "| a | b |\n|---|---|\n| 1 | 2 |\n", "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>"},
+		{"strikethrough", nil, "~~gone~~\n", "<p><del>gone</del></p>"},
+		{"task list", nil, "- [x] done\n- [ ] todo\n", `<li><input checked="" disabled="" type="checkbox"> done</li>`},
+		{"autolink", nil, "See https://example.org.\n", `<a href="https://example.org">https://example.org</a>.`},
+		{"footnote", nil, "Text[^1].\n\n[^1]: Note.\n", `<div class="footnotes" role="doc-endnotes">`},
+		{"raw html", nil, "<div class=\"note\">Hi</div>\n", `<div class="note">Hi</div>`},
+		{"heading id", nil, "## Getting Started\n", `<h2 id="getting-started">Getting Started</h2>`},
+		{"no gfm", map[string]interface{}{"gfm": false}, "~~kept~~\n", "<p>~~kept~~</p>"},
+		{"no html", map[string]interface{}{"html": false}, "<div>Hi</div>\n", "<!-- raw HTML omitted -->"},
+		{"hard wraps", map[string]interface{}{"hard_wraps": true}, "one\ntwo\n", "<p>one<br>\ntwo</p>"},
+		{"smartypants", map[string]interface{}{"smartypants": true}, "\"quoted\" -- dash\n", "<p>&ldquo;quoted&rdquo; &ndash; dash</p>"},
+		{"heading id prefix", map[string]interface{}{"heading_id_prefix": "doc-"}, "# Intro\n", `<h1 id="doc-intro">Intro</h1>`},
+		{"external links", map[string]interface{}{"target_blank": true, "nofollow": true}, "[in](/page) [out](https://example.org)\n",
+			`<a href="/page">in</a> <a href="https://example.org" rel="nofollow" target="_blank">out</a>`},
+	}
+	for _, test := range tests {
+		conf := markdownSettings(commonmark, test.conf)
+		if got := renderMarkdown(t, conf, test.source, RenderOptions{}); !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCommonMarkTOC(t *testing.T) {
+	conf := map[string]interface{}{"engine": "commonmark", "heading_id_prefix": "doc-"}
+	got := renderMarkdown(t, conf, "# One\n\n## Two & Three\n\n# Four\n", RenderOptions{TOC: true})
+	i := strings.Index(got, `<h1 id="doc-one">`)
+	if i < 0 {
+		t.Fatalf("no heading in %q", got)
+	}
+	toc := got[:i]
+	for _, want := range []string{`href="#doc-one"`, `href="#doc-two--three">Two &amp; Three</a>`, `href="#doc-four"`} {
+		if !strings.Contains(toc, want) {
+			t.Errorf("table of contents %q doesn't have %s", toc, want)
+		}
+	}
+}
+
+func TestCommonMarkSite(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"site.yaml":        "title: Test\nmarkdown:\n  engine: commonmark\n",
+		"content/index.md": "- [x] done\n",
+	})
+	if body := get(host, "/").Body.String(); !strings.Contains(body, `type="checkbox"`) {
+		t.Errorf("home page = %q, want a task list", body)
+	}
+
+	root := writeSite(t, map[string]string{"site.yaml": "title: Test\nmarkdown:\n  engine: missing\n"})
+	if host, err := New("localhost", root); err == nil {
+		host.Close()
+		t.Error("New loaded a site with an unknown markdown engine")
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/to"
+)
+
+// cacheRule sets the Cache-Control header for paths matching a pattern.
+type cacheRule struct {
+	pattern string
+	value   string
+}
+
+// matchPath returns true if a request path matches a pattern. Patterns use
+// the syntax of path.Match; a pattern ending in "/**" also matches everything
+// below that directory.
+func matchPath(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		dir := strings.TrimSuffix(pattern, "/**")
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+// matches returns true if the rule applies to a path.
+func (r cacheRule) matches(p string) bool {
+	return matchPath(r.pattern, p)
+}
+
+// loadCacheRules reads the "cache_control" list from site.yaml.
+func loadCacheRules(conf interface{}) ([]cacheRule, error) {
+	list, ok := conf.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	rules := make([]cacheRule, 0, len(list))
+	for i, item := range list {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cache_control entry %d is not a map", i+1)
+		}
+		rule := cacheRule{pattern: to.String(entry["path"]), value: to.String(entry["value"])}
+		if rule.pattern == "" {
+			return nil, fmt.Errorf("cache_control entry %d has no path", i+1)
+		}
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return nil, fmt.Errorf("cache_control entry %d: invalid pattern %q", i+1, rule.pattern)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// setCacheControl sets the Cache-Control header from the first rule that
+// matches reqpath, the request path below the mount path.
+func (ctx *Context) setCacheControl(reqpath string) {
+	ctx.RLock()
+	rules := ctx.cacheRules
+	ctx.RUnlock()
+
+	header := ctx.Response.Header()
+	reqpath = "/" + strings.TrimLeft(reqpath, "/")
+	for _, rule := range rules {
+		if rule.matches(reqpath) {
+			if rule.value != "" {
+				header.Set("Cache-Control", rule.value)
+			}
+			break
+		}
+	}
+
+	// Protected responses are never stored by shared caches.
+	if ctx.private {
+		if v := header.Get("Cache-Control"); v == "" || strings.Contains(v, "public") {
+			header.Set("Cache-Control", "private")
+		}
+	}
+}
+
+// pageValidators returns an ETag and modification time for a rendered page.
+// They change whenever one of the files the page is made of changes: the
+// given content files, the directory listings used for menus, site.yaml or
+// any template.
+func (ctx *Context) pageValidators(dir string, files ...string) (string, time.Time) {
+	ctx.RLock()
+	modified := ctx.settingsModified
+	if ctx.templatesModified.After(modified) {
+		modified = ctx.templatesModified
+	}
+	ctx.RUnlock()
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%d\x00", ctx.cacheKey(), modified.UnixNano())
+
+	for _, file := range append(files, ctx.menuDirs(dir)...) {
+		if file == "" {
+			continue
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", file, stat.ModTime().UnixNano(), stat.Size())
+		if stat.ModTime().After(modified) {
+			modified = stat.ModTime()
+		}
+	}
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum64()), modified
+}
+
+// menuDirs returns the directories whose listings go into the menus of a
+// page in dir: dir itself and its subdirectories for the menu, and the parent
+// directory, which the side menu falls back to, unless dir is the content
+// root. Adding or removing a file in any of them changes its modification
+// time.
+func (ctx *Context) menuDirs(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+	dir = path.Clean(dir)
+	dirs := []string{dir}
+	if docroot, err := ctx.GetContentPath(); err == nil && dir != path.Clean(docroot) {
+		dirs = append(dirs, path.Dir(dir))
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, path.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return dirs
+}
+
+// notModified sets the validators of a response and returns true, after
+// sending a 304, when the request's conditional headers show the client
+// already has the current version. If-None-Match takes precedence over
+// If-Modified-Since.
+func (ctx *Context) notModified(etag string, modified time.Time) bool {
+	w, req := ctx.Response, ctx.Request
+
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil || modified.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches does the weak comparison of an If-None-Match header against
+// an ETag.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
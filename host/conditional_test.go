@@ -0,0 +1,92 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPageValidatorsMenus(t *testing.T) {
+	// Without the render cache, pages are validated without waiting for the
+	// file watcher.
+	host := newTestHost(t, "localhost", map[string]string{
+		"site.yaml":               "render_cache:\n  max_size: 0\n",
+		"content/index.md":        "# Home\n",
+		"content/docs/a.md":       "# A\n",
+		"content/docs/sub/one.md": "# One\n",
+	})
+	docroot, err := host.GetContentPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revalidate := func(etag string) int {
+		req := httptest.NewRequest(http.MethodGet, "/docs/a", nil)
+		req.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		host.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// touch creates a file and moves the time of its directory forward, so
+	// the change shows even on file systems with coarse timestamps.
+	later := time.Now()
+	touch := func(file string) {
+		later = later.Add(time.Minute)
+		full := filepath.Join(docroot, file)
+		if err := ioutil.WriteFile(full, []byte("# New\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Dir(full), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"sibling", "docs/b.md"},
+		{"child", "docs/sub/two.md"},
+		{"parent", "other.md"},
+	}
+	for _, tt := range tests {
+		w := get(host, "/docs/a")
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: GET = %d with ETag %q", tt.name, w.Code, etag)
+		}
+		if code := revalidate(etag); code != http.StatusNotModified {
+			t.Fatalf("%s: unchanged page = %d, want 304", tt.name, code)
+		}
+		touch(tt.file)
+		if code := revalidate(etag); code != http.StatusOK {
+			t.Errorf("%s: after adding %s = %d, want 200", tt.name, tt.file, code)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Context holds everything that belongs to a single request. A new Context
+// is created for every request, so templates and funcMap helpers never read
+// request data from the shared Host.
+type Context struct {
+	// Host serving the request.
+	*Host
+	// Standard request.
+	Request *http.Request
+	// Response writer that keeps track of the response state.
+	Response *Response
+	// Host name as requested by the client, without the port.
+	HostName string
+	// Path the host is mounted at; empty when mounted at the root.
+	MountPath string
+	// Scheme the client used, and the path prefix a trusted reverse proxy
+	// publishes the site under; empty when there's no proxy prefix.
+	Scheme string
+	Prefix string
+	// True in dev mode, where error pages show the details of errors.
+	Dev bool
+	// Authenticated user and their groups; empty for anonymous requests.
+	User   string
+	Groups []string
+	// True when the response depends on the user.
+	private bool
+	// CSP nonce of the response; empty unless the host's headers use one.
+	Nonce string
+	// Called before rendering a page or search results, ending the request
+	// when it returns false; files are served without asking. Nil allows
+	// every request.
+	Allow func() bool
+}
+
+// Response wraps a http.ResponseWriter and records the status code and the
+// number of bytes actually written.
+type Response struct {
+	http.ResponseWriter
+	// Status code sent to the client; 0 until the header is written.
+	Status int
+	// Number of body bytes written so far.
+	Size int
+	// User the request was served to, for the access log; empty for
+	// anonymous requests.
+	User string
+}
+
+// WriteHeader records the status and sends the header.
+func (r *Response) WriteHeader(status int) {
+	if r.Status == 0 {
+		r.Status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written.
+func (r *Response) Write(b []byte) (int, error) {
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.Size += n
+	return n, err
+}
+
+// Flush sends buffered data to the client when the underlying writer
+// supports it.
+func (r *Response) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// NewContext creates the per-request context for a request served by host.
+func (host *Host) NewContext(w http.ResponseWriter, req *http.Request) *Context {
+	name := req.Host
+	if i := strings.LastIndex(name, ":"); i > -1 && !strings.HasSuffix(name, "]") {
+		name = name[:i]
+	}
+	response, ok := w.(*Response)
+	if !ok {
+		response = &Response{ResponseWriter: w}
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return &Context{
+		Host:      host,
+		Request:   req,
+		Response:  response,
+		HostName:  name,
+		MountPath: host.Path,
+		Scheme:    scheme,
+	}
+}
+
+// parseBaseURL checks the baseURL from site.yaml, which must be an absolute
+// http or https URL. It returns nil when there's none.
+func parseBaseURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid baseURL %s: %v", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("baseURL %s is not an absolute http or https URL", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("baseURL %s can't have a query or fragment", s)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u, nil
+}
+
+// BaseURL returns the public URL of the site from site.yaml, or nil when
+// links are built from the requests.
+func (host *Host) BaseURL() *url.URL {
+	host.RLock()
+	defer host.RUnlock()
+	return host.baseURL
+}
+
+// Link returns the path clients use for p, a path below the host's mount
+// path: below the path of the host's baseURL when it has one, or else below
+// the proxy prefix and the mount path.
+func (ctx *Context) Link(p string) string {
+	p = "/" + strings.TrimLeft(p, "/")
+	if base := ctx.BaseURL(); base != nil {
+		return base.Path + p
+	}
+	mount := strings.Trim(ctx.MountPath, "/")
+	if mount != "" {
+		p = "/" + mount + p
+	}
+	return ctx.Prefix + p
+}
+
+// AbsoluteURL returns the full URL of p, a path below the host's mount path,
+// using the host's baseURL or the scheme and host name the client used.
+func (ctx *Context) AbsoluteURL(p string) string {
+	if base := ctx.BaseURL(); base != nil {
+		return base.Scheme + "://" + base.Host + ctx.Link(p)
+	}
+	return ctx.Scheme + "://" + ctx.Request.Host + ctx.Link(p)
+}
+
+// funcMap returns the template functions that depend on the request.
+func (ctx *Context) funcMap() template.FuncMap {
+	return template.FuncMap{
+		"url":    ctx.url,
+		"anchor": ctx.anchor,
+		"asset":  ctx.asset,
+	}
+}
+
+// templates returns a copy of the host templates bound to this request.
+func (ctx *Context) templates() (*template.Template, error) {
+	ctx.RLock()
+	ht := ctx.TemplateGroup
+	ctx.RUnlock()
+	t, err := ht.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return t.Funcs(ctx.funcMap()), nil
+}
+
+// asset returns a relative URL.
+func (ctx *Context) asset(assetURL string) string {
+	if !isExternalLink(assetURL) {
+		return ctx.Link(assetURL)
+	}
+	return assetURL
+}
+
+// url returns an absolute URL.
+func (ctx *Context) url(pageURL string) string {
+	if !isExternalLink(pageURL) {
+		return ctx.AbsoluteURL(pageURL)
+	}
+	return pageURL
+}
+
+// anchor is a function for funcMap that writes links.
+func (ctx *Context) anchor(url, text string) template.HTML {
+	if isExternalLink(url) {
+		return template.HTML(fmt.Sprintf(`<a target="_blank" href="%s">%s</a>`, ctx.asset(url), text))
+	}
+	return template.HTML(fmt.Sprintf(`<a href="%s">%s</a>`, ctx.asset(url), text))
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		mount, prefix, baseURL string
+		p, link, absolute      string
+	}{
+		{"", "", "", "/", "/", "http://example.org/"},
+		{"", "", "", "/css/site.css", "/css/site.css", "http://example.org/css/site.css"},
+		{"/handbook", "", "", "/", "/handbook/", "http://example.org/handbook/"},
+		{"/handbook", "", "", "/search", "/handbook/search", "http://example.org/handbook/search"},
+		{"/handbook", "", "", "page", "/handbook/page", "http://example.org/handbook/page"},
+		{"/handbook", "/proxy", "", "/page", "/proxy/handbook/page", "http://example.org/proxy/handbook/page"},
+		{"", "/proxy", "", "/page", "/proxy/page", "http://example.org/proxy/page"},
+		{"/handbook", "/proxy", "https://docs.example.com/manual", "/page", "/manual/page", "https://docs.example.com/manual/page"},
+		{"", "", "https://docs.example.com", "/", "/", "https://docs.example.com/"},
+	}
+	for _, test := range tests {
+		host := &Host{RWMutex: new(sync.RWMutex)}
+		if test.baseURL != "" {
+			base, err := parseBaseURL(test.baseURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			host.baseURL = base
+		}
+		ctx := host.NewContext(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.org/", nil))
+		ctx.MountPath = test.mount
+		ctx.Prefix = test.prefix
+		if got := ctx.Link(test.p); got != test.link {
+			t.Errorf("Link(%q) mounted at %q below %q = %q, want %q", test.p, test.mount, test.prefix, got, test.link)
+		}
+		if got := ctx.AbsoluteURL(test.p); got != test.absolute {
+			t.Errorf("AbsoluteURL(%q) mounted at %q below %q = %q, want %q", test.p, test.mount, test.prefix, got, test.absolute)
+		}
+	}
+}
+
+func TestMountedSite(t *testing.T) {
+	host := newTestHost(t, "localhost/handbook", map[string]string{
+		"templates/index.tpl": `<a href="{{ asset "/css/site.css" }}">{{ .Content }}</a>`,
+		"content/index.md":    "Home\n",
+		"content/x.md":        "Page x, see [y](/y), [top](#top), [site](https://example.org/y), [cdn](//cdn.example.org/y) and [z](z).\n",
+		"content/y.md":        "Page y\n",
+	})
+
+	w := get(host, "/handbook/x")
+	if w.Code != 200 {
+		t.Fatalf("/handbook/x: got %d, want 200", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"Page x",
+		`href="/handbook/css/site.css"`,
+		`href="/handbook/y"`,
+		`href="#top"`,
+		`href="https://example.org/y"`,
+		`href="//cdn.example.org/y"`,
+		`href="z"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/handbook/x doesn't contain %s:\n%s", want, body)
+		}
+	}
+
+	if w := get(host, "/handbook"); w.Code != 200 || !strings.Contains(w.Body.String(), "Home") {
+		t.Errorf("/handbook: got %d %q, want the home page", w.Code, w.Body.String())
+	}
+	if w := get(host, "/handbookx"); w.Code == 200 {
+		t.Errorf("/handbookx: got %d, want it not to be served as x", w.Code)
+	}
+}
+
+func TestParseBaseURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"", "", false},
+		{"https://docs.example.com", "https://docs.example.com", false},
+		{"https://docs.example.com/manual/", "https://docs.example.com/manual", false},
+		{"http://localhost:8080/", "http://localhost:8080", false},
+		{"/manual", "", true},
+		{"ftp://docs.example.com", "", true},
+		{"https://docs.example.com/?lang=en", "", true},
+		{"https://docs.example.com/#top", "", true},
+		{"https://%zz", "", true},
+	}
+	for _, test := range tests {
+		u, err := parseBaseURL(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("parseBaseURL(%q): error %v", test.in, err)
+			continue
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != test.want {
+			t.Errorf("parseBaseURL(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
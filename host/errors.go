@@ -0,0 +1,113 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lnxjedi/dig"
+	"github.com/lnxjedi/luminos/page"
+)
+
+// serveError sends an error response. When the host's templates provide a
+// template named after the status, like 404.tpl, it is rendered as a normal
+// page; otherwise a plain text message is sent. The details of err are only
+// shown to the visitor in dev mode.
+func (ctx *Context) serveError(status int, err error) {
+	w := ctx.Response
+
+	if err != nil {
+		log.Printf("%s: %s: %v\n", ctx.Name, ctx.Request.URL.Path, err)
+	}
+
+	// Validators and caching rules belong to the page that failed.
+	h := w.Header()
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Del("Cache-Control")
+
+	details := ""
+	if ctx.Dev && err != nil {
+		details = err.Error()
+	}
+
+	if out, ok := ctx.renderError(status, details); ok {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write(ctx.withLiveReload(out))
+		return
+	}
+
+	message := http.StatusText(status)
+	if details != "" {
+		message += "\n\n" + details
+	}
+	http.Error(w, message, status)
+}
+
+// renderError renders the error template for status, returning false when
+// there is none or it fails.
+func (ctx *Context) renderError(status int, details string) ([]byte, bool) {
+	tpl := fmt.Sprintf("%d.tpl", status)
+
+	ht, err := ctx.templates()
+	if err != nil || ht.Lookup(tpl) == nil {
+		return nil, false
+	}
+
+	p := &page.Page{
+		Title:    http.StatusText(status),
+		Status:   status,
+		Error:    details,
+		BasePath: "/",
+		Query:    ctx.Request.URL.Query(),
+		Data:     dig.New(),
+		Host:     ctx,
+		User:     ctx.User,
+		Groups:   ctx.Groups,
+		Nonce:    ctx.Nonce,
+	}
+	ctx.RLock()
+	p.Site = ctx.Settings
+	ctx.RUnlock()
+
+	// Menus list the content root.
+	if docroot, err := ctx.GetContentPath(); err == nil {
+		p.FilePath = strings.TrimRight(docroot, pathSeparator) + pathSeparator
+		p.FileDir = p.FilePath
+		p.CreateBreadCrumb()
+		p.CreateMenu()
+		p.CreateSideMenu()
+	}
+
+	var out bytes.Buffer
+	if err := ht.ExecuteTemplate(&out, tpl, p); err != nil {
+		templateErrors.Inc(ctx.Name)
+		log.Printf("%s: rendering %s: %v\n", ctx.Name, tpl, err)
+		return nil, false
+	}
+	return out.Bytes(), true
+}
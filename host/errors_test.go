@@ -0,0 +1,92 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorTemplates(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"content/index.md":     "# Home\n",
+		"content/about.md":     "# About\n",
+		"content/broken.md":    "---\n#luminos\nTemplate: broken.tpl\n---\n# Broken\n",
+		"content/secret.md":    "---\n#luminos\nAccess: { Groups: [\"ops\"] }\n---\nsecret page\n",
+		"templates/404.tpl":    "{{ .Status }} {{ .Title }}{{ range .SideMenu }} [{{ .Text }}]{{ end }}",
+		"templates/403.tpl":    "{{ .Status }} {{ .Title }} for {{ .User }}",
+		"templates/500.tpl":    "{{ .Status }} {{ .Title }}: {{ .Error }}",
+		"templates/broken.tpl": "{{ template \"missing.tpl\" }}",
+	})
+
+	// Menus only list the pages the visitor can see.
+	tests := []struct {
+		name   string
+		target string
+		user   string
+		dev    bool
+		status int
+		body   string
+	}{
+		{"not found", "/missing", "", false, http.StatusNotFound, "404 Not Found [about] [broken]"},
+		{"forbidden", "/secret", "alice", false, http.StatusForbidden, "403 Forbidden for alice"},
+		{"server error", "/broken", "", false, http.StatusInternalServerError, "500 Internal Server Error: "},
+		{"server error in dev mode", "/broken", "", true, http.StatusInternalServerError, "500 Internal Server Error: html/template:broken.tpl"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		ctx := host.NewContext(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+		ctx.User, ctx.Dev = test.user, test.dev
+		ctx.Serve()
+
+		if w.Code != test.status {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("%s: Content-Type = %q", test.name, ct)
+		}
+		body := w.Body.String()
+		if !strings.HasPrefix(body, test.body) || (!test.dev && body != test.body) {
+			t.Errorf("%s: body = %q, want %q", test.name, body, test.body)
+		}
+	}
+}
+
+func TestErrorWithoutTemplate(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"content/index.md": "# Home\n",
+		// A broken error template falls back to plain text.
+		"templates/404.tpl": "{{ .Nothing.Here }}",
+	})
+	w := get(host, "/missing")
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("got %d %q, want a plain 404", w.Code, w.Header().Get("Content-Type"))
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "Not Found" {
+		t.Errorf("body = %q, want \"Not Found\"", body)
+	}
+	if w := get(host, "/"); w.Code != http.StatusOK {
+		t.Errorf("/: got %d", w.Code)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/lnxjedi/to"
+)
+
+// Placeholder in header values for the nonce of the request.
+const noncePlaceholder = "{nonce}"
+
+// headerRule sets headers on responses for paths matching a pattern.
+type headerRule struct {
+	pattern string
+	set     map[string]string
+}
+
+// headerPolicy holds the "headers" section of site.yaml.
+type headerPolicy struct {
+	// Headers of every response.
+	set map[string]string
+	// Overrides for paths, applied in order.
+	paths []headerRule
+}
+
+// loadHeaderMap reads a map of header names and values.
+func loadHeaderMap(conf interface{}) map[string]string {
+	m, _ := conf.(map[string]interface{})
+	set := make(map[string]string, len(m))
+	for name, value := range m {
+		set[name] = to.String(value)
+	}
+	return set
+}
+
+// loadHeaders reads the "headers" section of site.yaml.
+func loadHeaders(conf interface{}) (*headerPolicy, error) {
+	m, ok := conf.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	h := &headerPolicy{set: loadHeaderMap(m["set"])}
+
+	list, _ := m["paths"].([]interface{})
+	for i, item := range list {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("headers: path %d is not a map", i+1)
+		}
+		rule := headerRule{pattern: to.String(entry["path"]), set: loadHeaderMap(entry["set"])}
+		if rule.pattern == "" {
+			return nil, fmt.Errorf("headers: path %d has no path", i+1)
+		}
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return nil, fmt.Errorf("headers: path %d: invalid pattern %q", i+1, rule.pattern)
+		}
+		h.paths = append(h.paths, rule)
+	}
+
+	return h, nil
+}
+
+// newNonce returns a random value for CSP nonces. The URL-safe alphabet
+// needs no escaping in HTML attributes.
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// setHeaders applies the header policy of the host for reqpath, the request
+// path below the mount path. Empty values remove a header set before.
+func (ctx *Context) setHeaders(reqpath string) {
+	ctx.RLock()
+	h := ctx.headers
+	ctx.RUnlock()
+
+	if h == nil {
+		return
+	}
+
+	set := map[string]string{}
+	for name, value := range h.set {
+		set[name] = value
+	}
+	p := cleanPath(reqpath)
+	for _, rule := range h.paths {
+		if matchPath(rule.pattern, p) {
+			for name, value := range rule.set {
+				set[name] = value
+			}
+		}
+	}
+
+	header := ctx.Response.Header()
+	for name, value := range set {
+		if value == "" {
+			continue
+		}
+		if strings.Contains(value, noncePlaceholder) {
+			if ctx.Nonce == "" {
+				ctx.Nonce = newNonce()
+			}
+			value = strings.Replace(value, noncePlaceholder, ctx.Nonce, -1)
+		}
+		header.Set(name, value)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLoadHeaders(t *testing.T) {
+	if h, err := loadHeaders(nil); h != nil || err != nil {
+		t.Errorf("loadHeaders(nil) = %v, %v, want nil, nil", h, err)
+	}
+	for _, conf := range []map[string]interface{}{
+		{"paths": []interface{}{"/embed/**"}},
+		{"paths": []interface{}{map[string]interface{}{"set": map[string]interface{}{"X-Frame-Options": "DENY"}}}},
+		{"paths": []interface{}{map[string]interface{}{"path": "/[", "set": map[string]interface{}{}}}},
+	} {
+		if _, err := loadHeaders(conf); err == nil {
+			t.Errorf("loadHeaders(%v) succeeded", conf)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"site.yaml": `headers:
+  set:
+    X-Content-Type-Options: "nosniff"
+    Referrer-Policy: "no-referrer"
+    Content-Security-Policy: "script-src 'nonce-{nonce}'"
+  paths:
+    - path: "/embed/**"
+      set:
+        Content-Security-Policy: "frame-ancestors https://example.com"
+        Referrer-Policy: ""
+`,
+		"content/index.md":      "# Home\n",
+		"content/embed/page.md": "# Embedded\n",
+		"content/notes.txt":     "Notes\n",
+		"templates/index.tpl":   "<script nonce=\"{{ .Nonce }}\"></script>{{ .Content }}",
+	})
+
+	// An empty value removes a header for the path.
+	tests := []struct {
+		target   string
+		csp      string
+		referrer string
+	}{
+		{"/", "script-src 'nonce-", "no-referrer"},
+		{"/notes.txt", "script-src 'nonce-", "no-referrer"},
+		{"/missing", "script-src 'nonce-", "no-referrer"},
+		{"/embed/page", "frame-ancestors https://example.com", ""},
+	}
+	for _, test := range tests {
+		w := get(host, test.target)
+		if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s: X-Content-Type-Options = %q, want \"nosniff\"", test.target, got)
+		}
+		if got := w.Header().Get("Content-Security-Policy"); !strings.HasPrefix(got, test.csp) {
+			t.Errorf("%s: Content-Security-Policy = %q, want %q", test.target, got, test.csp)
+		}
+		if got := w.Header().Get("Referrer-Policy"); got != test.referrer {
+			t.Errorf("%s: Referrer-Policy = %q, want %q", test.target, got, test.referrer)
+		}
+	}
+
+	// Every response has its own nonce, even when the page comes from the
+	// render cache.
+	nonce := regexp.MustCompile(`'nonce-([^']+)'`)
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		w := get(host, "/")
+		m := nonce.FindStringSubmatch(w.Header().Get("Content-Security-Policy"))
+		if m == nil {
+			t.Fatalf("no nonce in %q", w.Header().Get("Content-Security-Policy"))
+		}
+		if seen[m[1]] {
+			t.Errorf("nonce %q was used twice", m[1])
+		}
+		seen[m[1]] = true
+		if body := w.Body.String(); !strings.Contains(body, `nonce="`+m[1]+`"`) {
+			t.Errorf("page doesn't use the nonce %q: %q", m[1], body)
+		}
+		if w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+			t.Errorf("got %d with ETag %q, want pages with a nonce not to be revalidated", w.Code, w.Header().Get("ETag"))
+		}
+	}
+}
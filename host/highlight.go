@@ -0,0 +1,488 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// token is a piece of highlighted code, with the class of its span; plain
+// text has no class.
+type token struct {
+	class string
+	text  string
+}
+
+// lexRule matches a token at the start of the code left to highlight.
+type lexRule struct {
+	re *regexp.Regexp
+	// Class of the whole match, or of each group when the pattern has them.
+	classes []string
+	// True for rules that only match at the beginning of a line.
+	bol bool
+}
+
+func rule(pattern string, classes ...string) lexRule {
+	return lexRule{re: regexp.MustCompile(`^(?:` + pattern + `)`), classes: classes}
+}
+
+func lineRule(pattern string, classes ...string) lexRule {
+	r := rule(pattern, classes...)
+	r.bol = true
+	return r
+}
+
+// words matches any of a list of keywords, as long as they aren't the start
+// of a longer word.
+func words(class string, list ...string) lexRule {
+	return rule(`(?:`+strings.Join(list, "|")+`)\b`, class)
+}
+
+// Rules shared by the C-like languages.
+var (
+	lineComment  = rule(`//[^\n]*`, "c1")
+	blockComment = rule(`/\*(?:[\s\S]*?\*/|[\s\S]*)`, "cm")
+	doubleQuoted = rule(`"(?:\\.|[^"\\\n])*"?`, "s2")
+	singleQuoted = rule(`'(?:\\.|[^'\\\n])*'?`, "s1")
+	number       = rule(`(?:0[xXbBoO][0-9a-fA-F_]+|\d[\d_]*(?:\.[\d_]*)?(?:[eE][+-]?\d+)?|\.\d[\d_]*(?:[eE][+-]?\d+)?)[a-zA-Z]*`, "m")
+	call         = rule(`([\pL_][\pL\pN_]*)(\s*\()`, "nf", "")
+	identifier   = rule(`[\pL_$][\pL\pN_$]*`)
+	operator     = rule(`[-+*/%&|^<>=!:~?]+`, "o")
+	whitespace   = rule(`\s+`)
+)
+
+// Lexers of the languages that fenced code blocks are highlighted for, by
+// the names they're given after the fence.
+var lexers = map[string][]lexRule{}
+
+func init() {
+	golang := []lexRule{
+		lineComment,
+		blockComment,
+		rule("`[^`]*`?", "sb"),
+		doubleQuoted,
+		rule(`'(?:\\.|[^'\\\n])*'?`, "sc"),
+		rule(`(func)(\s+)((?:\([^)\n]*\)\s*)?)([\pL_][\pL\pN_]*)`, "kd", "", "", "nf"),
+		rule(`(type)(\s+)([\pL_][\pL\pN_]*)`, "kd", "", "nc"),
+		words("kn", "package", "import"),
+		words("kd", "func", "type", "var", "const", "struct", "interface", "map", "chan"),
+		words("k", "break", "case", "continue", "default", "defer", "else", "fallthrough",
+			"for", "go", "goto", "if", "range", "return", "select", "switch"),
+		words("kc", "true", "false", "nil", "iota"),
+		words("kt", "any", "bool", "byte", "comparable", "complex64", "complex128", "error",
+			"float32", "float64", "int", "int8", "int16", "int32", "int64", "rune", "string",
+			"uint", "uint8", "uint16", "uint32", "uint64", "uintptr"),
+		words("nb", "append", "cap", "clear", "close", "complex", "copy", "delete", "imag",
+			"len", "make", "max", "min", "new", "panic", "print", "println", "real", "recover"),
+		call,
+		identifier,
+		number,
+		operator,
+		whitespace,
+	}
+
+	// Shell words run until a space or an operator, and keywords and
+	// builtins only count as whole words.
+	shellEnd := `([\s;&|)]|$)`
+	shell := []lexRule{
+		rule(`#[^\n]*`, "c1"),
+		rule(`"(?:\\.|[^"\\])*"?`, "s2"),
+		rule(`'[^']*'?`, "s1"),
+		rule(`\$(?:\{[^}\n]*\}|[A-Za-z_]\w*|[0-9@#?$!*-])`, "nv"),
+		rule(`\$\(\(?|\)\)?|\x60`, "o"),
+		rule(`(if|then|else|elif|fi|for|while|until|do|done|case|esac|in|function|select|return|local|export|readonly|declare|unset|shift|break|continue|exit)`+shellEnd, "k", ""),
+		rule(`(alias|cd|echo|eval|exec|false|printf|pwd|read|set|source|test|trap|true|wait)`+shellEnd, "nb", ""),
+		rule(`([A-Za-z_]\w*)(\+?=)`, "nv", "o"),
+		rule(`&&|\|\||[|&;<>]+`, "o"),
+		rule(`\\.`),
+		rule(`[^\s'"$;&|<>()\x60\\#][^\s'"$;&|<>()\x60\\]*`),
+		whitespace,
+	}
+
+	// Scalars end at a space, a flow indicator or the end of the line.
+	yamlEnd := `([ \t,\]}\n]|$)`
+	yaml := []lexRule{
+		lineRule(`(---|\.\.\.)([ \t]|\n|$)`, "gh", ""),
+		lineRule(`([ \t]*(?:-[ \t]+)*)([^\s#'"{}\[\],&*!|>%@\x60-][^\n]*?|-[^\s\n][^\n]*?|"[^"\n]*"|'[^'\n]*')([ \t]*:)([ \t]|\n|$)`, "", "nt", "", ""),
+		rule(`#[^\n]*`, "c1"),
+		rule(`"(?:\\.|[^"\\])*"?`, "s2"),
+		rule(`'(?:''|[^'])*'?`, "s1"),
+		rule(`(true|false|null|True|False|Null|TRUE|FALSE|NULL|~)`+yamlEnd, "kc", ""),
+		rule(`([-+]?(?:0x[0-9a-fA-F]+|\d[\d_]*(?:\.\d*)?(?:[eE][+-]?\d+)?|\.inf|\.nan))`+yamlEnd, "m", ""),
+		rule(`[&*][^\s,\[\]{}]+`, "nl"),
+		rule(`![^\s]*`, "kt"),
+		rule(`[|>][-+0-9]*`, "o"),
+		rule(`[\[\]{},]|-[ \t]|-\n`),
+		rule(`[^\s,\[\]{}#][^\s,\[\]{}]*`),
+		// Keys are only looked for at the start of lines, where the
+		// indentation is left for them to match.
+		rule(`\n|[ \t]+`),
+	}
+
+	json := []lexRule{
+		rule(`("(?:\\.|[^"\\\n])*")(\s*)(:)`, "nt", "", ""),
+		doubleQuoted,
+		words("kc", "true", "false", "null"),
+		rule(`-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?`, "m"),
+		rule(`[\[\]{},]`),
+		whitespace,
+	}
+
+	python := []lexRule{
+		rule(`#[^\n]*`, "c1"),
+		rule(`[rRbBuUfF]{0,2}(?:"""[\s\S]*?(?:"""|$)|'''[\s\S]*?(?:'''|$))`, "sd"),
+		rule(`[rRbBuUfF]{0,2}"(?:\\.|[^"\\\n])*"?`, "s2"),
+		rule(`[rRbBuUfF]{0,2}'(?:\\.|[^'\\\n])*'?`, "s1"),
+		rule(`@[\w.]+`, "nd"),
+		rule(`(def)(\s+)([\pL_][\pL\pN_]*)`, "kd", "", "nf"),
+		rule(`(class)(\s+)([\pL_][\pL\pN_]*)`, "kd", "", "nc"),
+		words("kn", "import", "from"),
+		words("k", "and", "as", "assert", "async", "await", "break", "case", "continue",
+			"del", "elif", "else", "except", "finally", "for", "global", "if", "in", "is",
+			"lambda", "match", "nonlocal", "not", "or", "pass", "raise", "return", "try",
+			"while", "with", "yield"),
+		words("kc", "True", "False", "None"),
+		words("bp", "self", "cls"),
+		words("nb", "abs", "all", "any", "bool", "dict", "enumerate", "filter", "float",
+			"getattr", "hasattr", "int", "isinstance", "len", "list", "map", "max", "min",
+			"object", "open", "print", "range", "repr", "set", "setattr", "sorted", "str",
+			"sum", "super", "tuple", "type", "zip"),
+		call,
+		identifier,
+		number,
+		operator,
+		whitespace,
+	}
+
+	javascript := []lexRule{
+		lineComment,
+		blockComment,
+		rule("`(?:\\\\.|[^`\\\\])*`?", "sb"),
+		doubleQuoted,
+		singleQuoted,
+		rule(`(function\*?)(\s+)([\pL_$][\pL\pN_$]*)`, "kd", "", "nf"),
+		rule(`(class)(\s+)([\pL_$][\pL\pN_$]*)`, "kd", "", "nc"),
+		words("kd", "var", "let", "const", "function", "class"),
+		words("kn", "import", "export", "from"),
+		words("k", "async", "await", "break", "case", "catch", "continue", "debugger",
+			"default", "delete", "do", "else", "extends", "finally", "for", "if", "in",
+			"instanceof", "new", "of", "return", "static", "super", "switch", "this",
+			"throw", "try", "typeof", "void", "while", "with", "yield"),
+		words("kc", "true", "false", "null", "undefined", "NaN", "Infinity"),
+		words("nb", "Array", "Boolean", "console", "Date", "document", "Error", "JSON",
+			"Map", "Math", "module", "Number", "Object", "Promise", "require", "Set",
+			"String", "window"),
+		call,
+		identifier,
+		number,
+		operator,
+		whitespace,
+	}
+
+	sqlWords := func(class string, list ...string) lexRule {
+		return rule(`(?i:`+strings.Join(list, "|")+`)\b`, class)
+	}
+	sql := []lexRule{
+		rule(`--[^\n]*`, "c1"),
+		blockComment,
+		rule(`'(?:''|[^'])*'?`, "s1"),
+		rule(`"(?:""|[^"])*"?|\x60[^\x60]*\x60?`, "nv"),
+		sqlWords("kc", "true", "false", "null"),
+		sqlWords("kt", "bigint", "blob", "boolean", "char", "date", "decimal", "double",
+			"float", "integer", "int", "json", "numeric", "real", "serial", "smallint",
+			"text", "timestamp", "uuid", "varchar"),
+		sqlWords("k", "add", "all", "alter", "and", "asc", "as", "begin", "between", "by",
+			"cascade", "case", "check", "commit", "constraint", "create", "cross",
+			"default", "delete", "desc", "distinct", "drop", "else", "end", "exists",
+			"foreign", "from", "full", "grant", "group", "having", "if", "index", "inner",
+			"insert", "into", "in", "is", "join", "key", "left", "like", "limit", "not",
+			"offset", "on", "order", "or", "outer", "primary", "references", "returning",
+			"right", "rollback", "select", "set", "table", "then", "transaction", "union",
+			"unique", "update", "using", "values", "view", "when", "where", "with"),
+		call,
+		identifier,
+		number,
+		rule(`[-+*/%<>=!|]+`, "o"),
+		whitespace,
+	}
+
+	diff := []lexRule{
+		lineRule(`(?:diff |index |\+\+\+ |--- |Only in )[^\n]*`, "gh"),
+		lineRule(`@@[^\n]*`, "gu"),
+		lineRule(`\+[^\n]*`, "gi"),
+		lineRule(`-[^\n]*`, "gd"),
+		rule(`[^\n]+`),
+		whitespace,
+	}
+
+	for names, rules := range map[string][]lexRule{
+		"go golang":              golang,
+		"sh bash shell zsh":      shell,
+		"yaml yml":               yaml,
+		"json":                   json,
+		"python py python3":      python,
+		"javascript js node mjs": javascript,
+		"sql mysql postgresql":   sql,
+		"diff patch":             diff,
+	} {
+		for _, name := range strings.Fields(names) {
+			lexers[name] = rules
+		}
+	}
+}
+
+// tokenize splits code into tokens with the rules of a language. Text that
+// no rule matches is left plain.
+func tokenize(rules []lexRule, code string) []token {
+	var tokens []token
+	add := func(class, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(tokens); n > 0 && tokens[n-1].class == class {
+			tokens[n-1].text += text
+			return
+		}
+		tokens = append(tokens, token{class, text})
+	}
+
+	for pos := 0; pos < len(code); {
+		rest := code[pos:]
+		matched := false
+		for _, r := range rules {
+			if r.bol && pos > 0 && code[pos-1] != '\n' {
+				continue
+			}
+			m := r.re.FindStringSubmatchIndex(rest)
+			if m == nil || m[1] == 0 {
+				continue
+			}
+			if len(m) == 2 {
+				class := ""
+				if len(r.classes) > 0 {
+					class = r.classes[0]
+				}
+				add(class, rest[:m[1]])
+			} else {
+				end := 0
+				for g := 1; g < len(m)/2 && g <= len(r.classes); g++ {
+					start, stop := m[2*g], m[2*g+1]
+					if start < end {
+						continue
+					}
+					add("", rest[end:start])
+					add(r.classes[g-1], rest[start:stop])
+					end = stop
+				}
+				add("", rest[end:m[1]])
+			}
+			pos += m[1]
+			matched = true
+			break
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(rest)
+			add("", rest[:size])
+			pos += size
+		}
+	}
+	return tokens
+}
+
+// codeOptions are the options of a fenced code block, given in braces after
+// its language: lines or ranges of lines to highlight, e.g. {3-5,8}, and
+// linenos or nolinenos to turn line numbers on or off.
+type codeOptions struct {
+	lineNumbers bool
+	highlighted map[int]bool
+}
+
+// parseCodeInfo splits the info string of a fenced code block into its
+// language and options.
+func parseCodeInfo(info string, lineNumbers bool) (string, codeOptions) {
+	opts := codeOptions{lineNumbers: lineNumbers}
+	info = strings.TrimSpace(info)
+	lang := info
+	if i := strings.IndexAny(info, " \t{"); i >= 0 {
+		lang = info[:i]
+	}
+
+	start := strings.Index(info, "{")
+	end := strings.LastIndex(info, "}")
+	if start < 0 || end < start {
+		return lang, opts
+	}
+	for _, item := range strings.FieldsFunc(info[start+1:end], func(r rune) bool { return r == ',' || r == ' ' }) {
+		switch item {
+		case "linenos":
+			opts.lineNumbers = true
+			continue
+		case "nolinenos":
+			opts.lineNumbers = false
+			continue
+		}
+		from, to := item, item
+		if i := strings.Index(item, "-"); i > 0 {
+			from, to = item[:i], item[i+1:]
+		}
+		first, err1 := strconv.Atoi(from)
+		last, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || first < 1 || last < first {
+			continue
+		}
+		if opts.highlighted == nil {
+			opts.highlighted = map[int]bool{}
+		}
+		for line := first; line <= last && line-first < 10000; line++ {
+			opts.highlighted[line] = true
+		}
+	}
+	return lang, opts
+}
+
+// highlightCode renders a fenced code block. Code in one of the languages
+// of lexers is split into spans classed like those of Pygments, inside
+// <pre class="highlight">, with line numbers and highlighted lines when the
+// block asks for them; other blocks are rendered like the markdown engines
+// do, unless they ask for line numbers or highlighted lines.
+func highlightCode(info string, code []byte, lineNumbers bool) []byte {
+	lang, opts := parseCodeInfo(info, lineNumbers)
+	rules, known := lexers[strings.ToLower(lang)]
+
+	var out bytes.Buffer
+	class := ""
+	if lang != "" {
+		class = fmt.Sprintf(` class="language-%s"`, template.HTMLEscapeString(lang))
+	}
+	if !known && !opts.lineNumbers && opts.highlighted == nil {
+		fmt.Fprintf(&out, "<pre><code%s>%s</code></pre>\n", class, template.HTMLEscapeString(string(code)))
+		return out.Bytes()
+	}
+
+	source := strings.TrimSuffix(string(code), "\n")
+	tokens := []token{{text: source}}
+	if known {
+		tokens = tokenize(rules, source)
+	}
+
+	// Spans are closed at the end of each line, and opened again on the
+	// next, so that lines can be wrapped in their own span.
+	var lines [][]token
+	line := []token{}
+	for _, t := range tokens {
+		parts := strings.Split(t.text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				lines = append(lines, line)
+				line = []token{}
+			}
+			if part != "" {
+				line = append(line, token{t.class, part})
+			}
+		}
+	}
+	lines = append(lines, line)
+
+	width := len(strconv.Itoa(len(lines)))
+	fmt.Fprintf(&out, `<pre class="highlight"><code%s>`, class)
+	for i, line := range lines {
+		n := i + 1
+		if opts.highlighted[n] {
+			out.WriteString(`<span class="hll">`)
+		}
+		if opts.lineNumbers {
+			fmt.Fprintf(&out, `<span class="ln">%*d</span>`, width, n)
+		}
+		for _, t := range line {
+			if t.class == "" {
+				out.WriteString(template.HTMLEscapeString(t.text))
+			} else {
+				fmt.Fprintf(&out, `<span class="%s">%s</span>`, t.class, template.HTMLEscapeString(t.text))
+			}
+		}
+		out.WriteString("\n")
+		if opts.highlighted[n] {
+			out.WriteString(`</span>`)
+		}
+	}
+	out.WriteString("</code></pre>\n")
+	return out.Bytes()
+}
+
+// highlightStyles are the rules of the stylesheet for highlighted code, by
+// the class they apply to.
+var highlightStyles = map[string]struct {
+	style, name string
+}{
+	"hll": {"display: block; background-color: #ffc", "Highlighted line"},
+	"ln":  {"margin-right: 1em; color: #bbb; user-select: none", "Line number"},
+	"c1":  {"color: #999", "Comment.Single"},
+	"cm":  {"color: #09f; font-style: italic", "Comment.Multiline"},
+	"k":   {"color: #069", "Keyword"},
+	"kc":  {"color: #069", "Keyword.Constant"},
+	"kd":  {"color: #069", "Keyword.Declaration"},
+	"kn":  {"color: #069", "Keyword.Namespace"},
+	"kt":  {"color: #078", "Keyword.Type"},
+	"m":   {"color: #f60", "Literal.Number"},
+	"s1":  {"color: #c30", "Literal.String.Single"},
+	"s2":  {"color: #c30", "Literal.String.Double"},
+	"sb":  {"color: #c30", "Literal.String.Backtick"},
+	"sc":  {"color: #c30", "Literal.String.Char"},
+	"sd":  {"color: #c30; font-style: italic", "Literal.String.Doc"},
+	"o":   {"color: #555", "Operator"},
+	"nb":  {"color: #366", "Name.Builtin"},
+	"bp":  {"color: #366", "Name.Builtin.Pseudo"},
+	"nc":  {"color: #0a8", "Name.Class"},
+	"nd":  {"color: #99f", "Name.Decorator"},
+	"nf":  {"color: #c0f", "Name.Function"},
+	"nl":  {"color: #99f", "Name.Label"},
+	"nt":  {"color: #2f6f9f", "Name.Tag"},
+	"nv":  {"color: #033", "Name.Variable"},
+	"gd":  {"background-color: #fcc", "Generic.Deleted"},
+	"gi":  {"background-color: #cfc", "Generic.Inserted"},
+	"gh":  {"color: #030; font-weight: bold", "Generic.Heading"},
+	"gu":  {"color: #030", "Generic.Subheading"},
+}
+
+// Stylesheet returns the CSS for the classes of highlighted code.
+func Stylesheet() string {
+	classes := make([]string, 0, len(highlightStyles))
+	for class := range highlightStyles {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	var out strings.Builder
+	out.WriteString(".highlight { overflow-x: auto; }\n")
+	for _, class := range classes {
+		s := highlightStyles[class]
+		fmt.Fprintf(&out, ".highlight .%s { %s; } /* %s */\n", class, s.style, s.name)
+	}
+	return out.String()
+}
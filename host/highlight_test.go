@@ -0,0 +1,176 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHighlightGo(t *testing.T) {
+	code := "package main\n\n// Run runs.\nfunc Run(n int) error {\n\treturn fmt.Errorf(\"%d < 2\", n+1)\n}\n"
+	want := `<pre class="highlight"><code class="language-go"><span class="kn">package</span> main
+
+<span class="c1">// Run runs.</span>
+<span class="kd">func</span> <span class="nf">Run</span>(n <span class="kt">int</span>) <span class="kt">error</span> {
+	<span class="k">return</span> fmt.<span class="nf">Errorf</span>(<span class="s2">&#34;%d &lt; 2&#34;</span>, n<span class="o">+</span><span class="m">1</span>)
+}
+</code></pre>
+`
+	if got := string(highlightCode("go", []byte(code), false)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHighlightLines(t *testing.T) {
+	code := []byte(strings.Repeat("x := 1\n", 10))
+	got := string(highlightCode("go {2-3,10 linenos}", code, false))
+	lines := strings.Split(strings.TrimPrefix(got, `<pre class="highlight"><code class="language-go">`), "\n")
+	for i, want := range map[int]string{
+		0: `<span class="ln"> 1</span>x <span class="o">:=</span> <span class="m">1</span>`,
+		1: `<span class="hll"><span class="ln"> 2</span>x <span class="o">:=</span> <span class="m">1</span>`,
+		2: `</span><span class="hll"><span class="ln"> 3</span>x <span class="o">:=</span> <span class="m">1</span>`,
+		3: `</span><span class="ln"> 4</span>x <span class="o">:=</span> <span class="m">1</span>`,
+		9: `<span class="hll"><span class="ln">10</span>x <span class="o">:=</span> <span class="m">1</span>`,
+	} {
+		if lines[i] != want {
+			t.Errorf("line %d = %q, want %q", i+1, lines[i], want)
+		}
+	}
+}
+
+func TestHighlightPlain(t *testing.T) {
+	tests := []struct {
+		info        string
+		lineNumbers bool
+		want        string
+	}{
+		{"", false, "<pre><code>a &lt; b\n</code></pre>\n"},
+		{"text", false, "<pre><code class=\"language-text\">a &lt; b\n</code></pre>\n"},
+		{"text", true, "<pre class=\"highlight\"><code class=\"language-text\"><span class=\"ln\">1</span>a &lt; b\n</code></pre>\n"},
+		{"text {1}", false, "<pre class=\"highlight\"><code class=\"language-text\"><span class=\"hll\">a &lt; b\n</span></code></pre>\n"},
+		{"go {nolinenos}", true, "<pre class=\"highlight\"><code class=\"language-go\">a <span class=\"o\">&lt;</span> b\n</code></pre>\n"},
+	}
+	for _, test := range tests {
+		if got := string(highlightCode(test.info, []byte("a < b\n"), test.lineNumbers)); got != test.want {
+			t.Errorf("highlightCode(%q, %v) = %q, want %q", test.info, test.lineNumbers, got, test.want)
+		}
+	}
+}
+
+func TestParseCodeInfo(t *testing.T) {
+	tests := []struct {
+		info        string
+		lineNumbers bool
+		lang        string
+		numbered    bool
+		highlighted string
+	}{
+		{"go", false, "go", false, ""},
+		{"go", true, "go", true, ""},
+		{" go {3-5,8} ", false, "go", false, "3 4 5 8"},
+		{"go{linenos}", false, "go", true, ""},
+		{"go {nolinenos, 2}", true, "go", false, "2"},
+		// Ranges that make no sense are ignored.
+		{"go {0,5-3,x,2-}", false, "go", false, ""},
+		{"{1}", false, "", false, "1"},
+	}
+	for _, test := range tests {
+		lang, opts := parseCodeInfo(test.info, test.lineNumbers)
+		var lines []string
+		for n := 1; n <= 10; n++ {
+			if opts.highlighted[n] {
+				lines = append(lines, strconv.Itoa(n))
+			}
+		}
+		if lang != test.lang || opts.lineNumbers != test.numbered || strings.Join(lines, " ") != test.highlighted {
+			t.Errorf("parseCodeInfo(%q, %v) = %q, %v, %q", test.info, test.lineNumbers, lang, opts.lineNumbers, lines)
+		}
+	}
+}
+
+func TestStylesheet(t *testing.T) {
+	css := Stylesheet()
+	if !strings.HasPrefix(css, ".highlight {") {
+		t.Errorf("stylesheet starts with %q", strings.SplitN(css, "\n", 2)[0])
+	}
+	// Every class the lexers use has a style.
+	for lang, rules := range lexers {
+		for _, r := range rules {
+			for _, class := range r.classes {
+				if class != "" && !strings.Contains(css, ".highlight ."+class+" {") {
+					t.Errorf("%s: class %s has no style", lang, class)
+				}
+			}
+		}
+	}
+	for _, class := range []string{"hll", "ln"} {
+		if !strings.Contains(css, ".highlight ."+class+" {") {
+			t.Errorf("class %s has no style", class)
+		}
+	}
+}
+
+func TestMarkdownHighlight(t *testing.T) {
+	source := "```go {linenos}\nvar x\n```\n\n```go\nvar y\n```\n"
+	for _, engine := range []string{"blackfriday", "commonmark"} {
+		got := renderMarkdown(t, map[string]interface{}{"engine": engine}, source, RenderOptions{})
+		for _, want := range []string{
+			`<span class="ln">1</span><span class="kd">var</span> x`,
+			"<code class=\"language-go\"><span class=\"kd\">var</span> y\n",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: %q doesn't have %q", engine, got, want)
+			}
+		}
+
+		got = renderMarkdown(t, map[string]interface{}{"engine": engine, "highlight": false}, source, RenderOptions{})
+		if strings.Contains(got, "<span") || strings.Count(got, `<code class="language-go">`) != 2 {
+			t.Errorf("%s: want plain go blocks with highlight turned off: %q", engine, got)
+		}
+		got = renderMarkdown(t, map[string]interface{}{"engine": engine, "line_numbers": true}, source, RenderOptions{})
+		if strings.Count(got, `<span class="ln">`) != 2 {
+			t.Errorf("%s: want every block numbered: %q", engine, got)
+		}
+	}
+}
+
+func TestJoinFenceOptions(t *testing.T) {
+	tests := []struct {
+		source, want string
+	}{
+		{"```go {linenos, 3-5}\nx\n```\n", "```go{linenos,3-5}\nx\n```\n"},
+		{"  ~~~ sh {nolinenos}  \r\nx\r\n~~~\r\n", "  ~~~ sh{nolinenos}\r\nx\r\n~~~\r\n"},
+		{"```go\nx\n```\n", "```go\nx\n```\n"},
+		{"```{1 2}\nx\n```\n", "```{1,2}\nx\n```\n"},
+		// Fences inside code blocks are code.
+		{"````md\n```go {linenos}\n```\n````\n```go {2}\n```\n", "````md\n```go {linenos}\n```\n````\n```go{2}\n```\n"},
+		{"~~~\n```go {1}\n~~~\n", "~~~\n```go {1}\n~~~\n"},
+		{"Text {with braces}\n", "Text {with braces}\n"},
+	}
+	for _, test := range tests {
+		if got := string(joinFenceOptions([]byte(test.source))); got != test.want {
+			t.Errorf("joinFenceOptions(%q) = %q, want %q", test.source, got, test.want)
+		}
+	}
+}
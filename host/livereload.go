@@ -0,0 +1,248 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/to"
+)
+
+// Path below the mount path where pages listen for reload events.
+const liveReloadPath = "/_luminos/live-reload"
+
+// Time to wait for more changes before telling pages to reload, so that
+// saving many files at once reloads them only once.
+const liveReloadDelay = 300 * time.Millisecond
+
+// Interval of the comments sent to keep idle event streams open.
+const liveReloadKeepAlive = 30 * time.Second
+
+// liveReload tells the pages open in browsers to reload when the site
+// changes, over Server-Sent Events.
+type liveReload struct {
+	sync.Mutex
+	// Channels of the event streams currently open.
+	clients map[chan bool]bool
+	// Pending reload, waiting for changes to settle.
+	timer *time.Timer
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{clients: map[chan bool]bool{}}
+}
+
+// subscribe returns a channel that receives true when pages should reload.
+// It's closed, after receiving true, when the host goes away.
+func (lr *liveReload) subscribe() chan bool {
+	c := make(chan bool, 1)
+	lr.Lock()
+	lr.clients[c] = true
+	lr.Unlock()
+	return c
+}
+
+func (lr *liveReload) unsubscribe(c chan bool) {
+	lr.Lock()
+	delete(lr.clients, c)
+	lr.Unlock()
+}
+
+// notify schedules a reload of all pages after liveReloadDelay, or pushes
+// back the one already scheduled.
+func (lr *liveReload) notify() {
+	if lr == nil {
+		return
+	}
+	lr.Lock()
+	defer lr.Unlock()
+	if lr.timer != nil {
+		lr.timer.Stop()
+	}
+	lr.timer = time.AfterFunc(liveReloadDelay, lr.broadcast)
+}
+
+func (lr *liveReload) broadcast() {
+	lr.Lock()
+	defer lr.Unlock()
+	for c := range lr.clients {
+		select {
+		case c <- true:
+		default:
+		}
+	}
+}
+
+// close reloads the pages still listening, since whatever replaces the
+// host may serve them differently, and ends their streams.
+func (lr *liveReload) close() {
+	if lr == nil {
+		return
+	}
+	lr.Lock()
+	defer lr.Unlock()
+	if lr.timer != nil {
+		lr.timer.Stop()
+	}
+	for c := range lr.clients {
+		select {
+		case c <- true:
+		default:
+		}
+		close(c)
+		delete(lr.clients, c)
+	}
+}
+
+// LiveReload makes pages of the host reload in the browser whenever its
+// content, templates, webroot or site.yaml change. It's meant for dev mode.
+func (host *Host) LiveReload() {
+	host.RLock()
+	enabled := host.live != nil
+	webrootdir := to.String(host.Settings.Get("content", "webroot"))
+	host.RUnlock()
+	if enabled {
+		return
+	}
+
+	if webrootdir == "" {
+		webrootdir = "webroot"
+	}
+	webroot, err := filepath.Abs(path.Join(host.DocumentRoot, webrootdir))
+	if err != nil {
+		webroot = ""
+	}
+
+	host.Lock()
+	host.live = newLiveReload()
+	host.webrootRoot = webroot
+	host.Unlock()
+
+	if webroot != "" {
+		host.watchTree(webroot)
+	}
+}
+
+// StopLiveReload ends the event streams of the pages listening for reloads,
+// which otherwise stay open until their clients go away.
+func (host *Host) StopLiveReload() {
+	host.RLock()
+	lr := host.live
+	host.RUnlock()
+	lr.close()
+}
+
+// reloadPages tells the pages open in browsers to reload, when live reload
+// is enabled.
+func (host *Host) reloadPages() {
+	host.RLock()
+	lr := host.live
+	host.RUnlock()
+	lr.notify()
+}
+
+// inWebroot returns true if a file is part of the webroot tree watched for
+// live reload.
+func (host *Host) inWebroot(file string) bool {
+	host.RLock()
+	root := host.webrootRoot
+	host.RUnlock()
+	return root != "" && (file == root || strings.HasPrefix(file, root+string(os.PathSeparator)))
+}
+
+// serveLiveReload streams reload events to a page. The stream ends when
+// the client goes away, or when write_timeout ends it, after which the
+// browser connects again.
+func (ctx *Context) serveLiveReload() {
+	ctx.RLock()
+	lr := ctx.live
+	ctx.RUnlock()
+
+	w := ctx.Response
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 1000\n\n")
+	w.Flush()
+
+	c := lr.subscribe()
+	defer lr.unsubscribe(c)
+
+	keepAlive := time.NewTicker(liveReloadKeepAlive)
+	defer keepAlive.Stop()
+
+	done := ctx.Request.Context().Done()
+	for {
+		select {
+		case reload, ok := <-c:
+			if reload {
+				fmt.Fprint(w, "event: reload\ndata: reload\n\n")
+				w.Flush()
+			}
+			if !ok {
+				return
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			w.Flush()
+		case <-done:
+			return
+		}
+	}
+}
+
+// withLiveReload adds the script that listens for reload events to an HTML
+// page, when live reload is enabled.
+func (ctx *Context) withLiveReload(page []byte) []byte {
+	ctx.RLock()
+	enabled := ctx.live != nil
+	ctx.RUnlock()
+	if !enabled {
+		return page
+	}
+
+	nonce := ""
+	if ctx.Nonce != "" {
+		nonce = fmt.Sprintf(` nonce="%s"`, ctx.Nonce)
+	}
+	script := fmt.Sprintf(`<script%s>new EventSource("%s").addEventListener("reload", function() { location.reload(); });</script>`,
+		nonce, template.JSEscapeString(ctx.Link(liveReloadPath)))
+
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		i = len(page)
+	}
+	out := make([]byte, 0, len(page)+len(script))
+	out = append(out, page[:i]...)
+	out = append(out, script...)
+	return append(out, page[i:]...)
+}
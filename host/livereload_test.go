@@ -0,0 +1,121 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveReloadScript(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"content/index.md":    "# Home\n",
+		"templates/index.tpl": "<html><body>{{ .Content }}</body></html>",
+	})
+
+	if body := get(host, "/").Body.String(); strings.Contains(body, "<script") {
+		t.Errorf("script added without live reload: %q", body)
+	}
+	if w := get(host, liveReloadPath); w.Code != http.StatusNotFound {
+		t.Errorf("%s = %d without live reload, want 404", liveReloadPath, w.Code)
+	}
+
+	host.LiveReload()
+	w := httptest.NewRecorder()
+	ctx := host.NewContext(w, httptest.NewRequest(http.MethodGet, "/handbook/", nil))
+	ctx.MountPath = "/handbook"
+	ctx.Serve()
+	body := w.Body.String()
+	want := `<script>new EventSource("/handbook/_luminos/live-reload")`
+	if !strings.Contains(body, want) || !strings.HasSuffix(body, "</script></body></html>") {
+		t.Errorf("page = %q, want %q before </body>", body, want)
+	}
+}
+
+func TestLiveReloadEvents(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{"content/index.md": "# Home\n"})
+	host.LiveReload()
+	server := httptest.NewServer(host)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + liveReloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	waitFor := func(want string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("stream ended before %q", want)
+				}
+				if line == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %q in the stream", want)
+			}
+		}
+	}
+	waitFor("retry: 1000")
+
+	content, _ := host.GetContentPath()
+	if err := ioutil.WriteFile(filepath.Join(content, "index.md"), []byte("# Changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("event: reload")
+
+	// Pages still listening reload when the host goes away.
+	host.StopLiveReload()
+	waitFor("event: reload")
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the stream wasn't ended")
+		}
+	}
+}
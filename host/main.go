@@ -30,8 +30,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -41,9 +43,9 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/ghodss/yaml"
 	"github.com/lnxjedi/dig"
+	"github.com/lnxjedi/luminos/accesslog"
 	"github.com/lnxjedi/luminos/page"
 	"github.com/lnxjedi/to"
-	"github.com/russross/blackfriday"
 )
 
 const (
@@ -74,16 +76,44 @@ type Host struct {
 	*sync.RWMutex
 	// Function map for template.
 	template.FuncMap
-	// Standard request.
-	*http.Request
-	// Standard response writer.
-	http.ResponseWriter
 	// Function map
 	funcMap template.FuncMap
 	// File watcher
 	Watcher *fsnotify.Watcher
 	// Template root
 	TemplateRoot string
+	// Access log from site.yaml; nil when the host uses the global log.
+	AccessLog *accesslog.Logger
+	// Cache-Control rules from site.yaml.
+	cacheRules []cacheRule
+	// Modification times of site.yaml and of the newest template.
+	settingsModified  time.Time
+	templatesModified time.Time
+	// Rendered pages and the absolute path of the content tree it watches.
+	cache       *renderCache
+	contentRoot string
+	// Redirects from site.yaml and from the Aliases of pages; the aliases
+	// are collected again after content changed.
+	redirects      []*Redirect
+	aliasList      []*Redirect
+	aliasesStale   bool
+	aliasesScanned time.Time
+	// Authentication from site.yaml; nil when there's none.
+	auth *authConfig
+	// Response headers from site.yaml; nil when there are none.
+	headers *headerPolicy
+	// Public URL of the site from site.yaml; nil when links are built from
+	// the requests.
+	baseURL *url.URL
+	// Markdown renderer from site.yaml.
+	markdown MarkdownRenderer
+	// Live reload of pages in dev mode, and the absolute path of the webroot
+	// it watches; nil when disabled.
+	live        *liveReload
+	webrootRoot string
+	// Time of the last reload and its error, if any.
+	reloaded  time.Time
+	reloadErr error
 }
 
 // Page frontmatter
@@ -93,8 +123,14 @@ type frontMatter struct {
 	Raw bool
 	// Set MDTOC to true to generate a TOC from Markdown
 	MDTOC bool
+	// Markdown settings for the page, overriding those of site.yaml
+	Markdown map[string]interface{}
 	// Arbitrary data for the page
 	Data dig.InterfaceMap
+	// Old paths of the page, which redirect to it
+	Aliases []string
+	// Who may read the page, overriding the protected paths of site.yaml
+	Access *Access
 }
 
 type structuredContent struct {
@@ -152,7 +188,7 @@ func (host *Host) GetIndexPath() (string, error) {
 		if err != nil {
 			return "", err
 		}
-		return path.Join(wd, cpath, "search.cdb"), nil
+		return filepath.Abs(path.Join(cpath, "search.cdb"))
 	}
 }
 
@@ -197,31 +233,30 @@ func readRawFile(file string) (string, error) {
 // Close removes the watcher that is currently associated with the host.
 func (host *Host) Close() {
 	host.Watcher.Close()
-}
-
-// asset returns a relative URL.
-func (host *Host) asset(assetURL string) string {
-	if !host.isExternalLink(assetURL) {
-		assetURL = strings.TrimLeft(assetURL, "/")
-		p := strings.Trim(host.Path, "/")
-		if p == "" {
-			return "/" + assetURL
-		}
-		return "/" + p + "/" + assetURL
+	host.StopLiveReload()
+	host.Lock()
+	if host.AccessLog != nil {
+		host.AccessLog.Close()
+		host.AccessLog = nil
 	}
-	return assetURL
+	host.Unlock()
 }
 
-// url returns an absolute URL.
-func (host *Host) url(url string) string {
-	if host.isExternalLink(url) == false {
-		return "//" + host.Request.Host + "/" + strings.TrimLeft(url, "/")
+// LogAccess writes an entry to the host's access log. It returns false when
+// the host has no access log of its own.
+func (host *Host) LogAccess(e *accesslog.Entry) bool {
+	host.RLock()
+	logger := host.AccessLog
+	host.RUnlock()
+	if logger == nil {
+		return false
 	}
-	return url
+	logger.Log(e)
+	return true
 }
 
 // isExternalLink returns true if the given URL is outside this host.
-func (host *Host) isExternalLink(url string) bool {
+func isExternalLink(url string) bool {
 	return isExternalLinkPattern.MatchString(url)
 }
 
@@ -254,14 +289,6 @@ func getInt(i interface{}) int {
 	}
 }
 
-// Function for funcMap that writes links.
-func (host *Host) anchor(url, text string) template.HTML {
-	if host.isExternalLink(url) {
-		return template.HTML(fmt.Sprintf(`<a target="_blank" href="%s">%s</a>`, host.asset(url), text))
-	}
-	return template.HTML(fmt.Sprintf(`<a href="%s">%s</a>`, host.asset(url), text))
-}
-
 // guessFile checks for files names and returns a guessed name.
 func guessFile(file string, descend bool) (f string, s os.FileInfo) {
 	var err error
@@ -302,10 +329,63 @@ func guessFile(file string, descend bool) (f string, s os.FileInfo) {
 	return
 }
 
+// parseFrontMatter reads the frontmatter at the start of a file's contents
+// into fm and returns the contents that follow it. Frontmatter is only read
+// when its first line is "#luminos", except in _defaults files.
+func parseFrontMatter(file string, buf []byte, defaults bool, fm *frontMatter) ([]byte, error) {
+	// maximum length of frontmatter start delimiter always < 16
+	peek := make([]byte, 32)
+	copy(peek, buf)
+	r := bytes.NewBuffer(peek)
+	firstLine, err := r.ReadString('\n')
+	if err == nil {
+		var end string
+		switch firstLine {
+		case "---\n":
+			end = "---\n"
+		case "<!--\n":
+			end = "-->\n"
+		case "```yaml\n":
+			end = "```\n"
+		}
+		if len(end) != 0 {
+			var secondLine string
+			if !defaults {
+				secondLine, _ = r.ReadString('\n')
+			}
+			if defaults || secondLine == "#luminos\n" {
+				r = bytes.NewBuffer(buf)
+				r.ReadString('\n')
+				var fmb bytes.Buffer
+				for {
+					line, err := r.ReadBytes('\n')
+					if err != nil && err != io.EOF {
+						msg := fmt.Sprintf("error reading frontmatter from %s: %v", file, err)
+						log.Println(msg)
+						return nil, errors.New(msg)
+					}
+					if string(line) == end || err == io.EOF {
+						break
+					}
+					fmb.Write(line)
+				}
+				err := yaml.Unmarshal(fmb.Bytes(), fm)
+				if err != nil {
+					msg := fmt.Sprintf("invalid frontmatter reading %s: %v", file, err)
+					log.Println(msg)
+					return nil, errors.New(msg)
+				}
+				buf = r.Bytes()
+			}
+		}
+	}
+	return buf, nil
+}
+
 // readContentFile opens a file and reads its contents and frontmatter.
 // If the file has the "*.md" extension, the content is rendered to HTML
 // unless Raw is set in the frontmatter.
-func (host *Host) readContentFile(file string, defaults bool, sc *structuredContent) error {
+func (ctx *Context) readContentFile(file string, defaults bool, sc *structuredContent) error {
 	var buf []byte
 	var err error
 
@@ -315,7 +395,7 @@ func (host *Host) readContentFile(file string, defaults bool, sc *structuredCont
 
 	if strings.HasSuffix(file, ".tpl") {
 		var out bytes.Buffer
-		tpl, err := template.New("").Funcs(host.funcMap).Parse(string(buf))
+		tpl, err := template.New("").Funcs(ctx.Host.funcMap).Funcs(ctx.funcMap()).Parse(string(buf))
 		if err != nil {
 			return err
 		}
@@ -324,70 +404,26 @@ func (host *Host) readContentFile(file string, defaults bool, sc *structuredCont
 		}
 		file = file[:len(file)-4]
 		buf = out.Bytes()
-	} else {
-		// maximum length of frontmatter start delimiter always < 16
-		peek := make([]byte, 32)
-		copy(peek, buf)
-		r := bytes.NewBuffer(peek)
-		firstLine, err := r.ReadString('\n')
-		if err == nil {
-			var end string
-			switch firstLine {
-			case "---\n":
-				end = "---\n"
-			case "<!--\n":
-				end = "-->\n"
-			case "```yaml\n":
-				end = "```\n"
-			}
-			if len(end) != 0 {
-				var secondLine string
-				if !defaults {
-					secondLine, _ = r.ReadString('\n')
-				}
-				if defaults || secondLine == "#luminos\n" {
-					r = bytes.NewBuffer(buf)
-					r.ReadString('\n')
-					var fmb bytes.Buffer
-					for {
-						line, err := r.ReadBytes('\n')
-						if err != nil && err != io.EOF {
-							msg := fmt.Sprintf("error reading frontmatter from %s: %v", file, err)
-							log.Println(msg)
-							return errors.New(msg)
-						}
-						if string(line) == end || err == io.EOF {
-							break
-						}
-						fmb.Write(line)
-					}
-					err := yaml.Unmarshal(fmb.Bytes(), &sc.pageInfo)
-					if err != nil {
-						msg := fmt.Sprintf("invalid frontmatter reading %s: %v", file, err)
-						log.Println(msg)
-						return errors.New(msg)
-					}
-					buf = r.Bytes()
-				}
-			}
-		}
+	} else if buf, err = parseFrontMatter(file, buf, defaults, &sc.pageInfo); err != nil {
+		return err
 	}
 
 	if strings.HasSuffix(file, ".md") && !sc.pageInfo.Raw {
-		htmlflags := blackfriday.FootnoteReturnLinks
-		if sc.pageInfo.MDTOC {
-			htmlflags |= blackfriday.TOC
-		}
-		renderparams := blackfriday.HTMLRendererParameters{
-			Flags: htmlflags,
+		ctx.RLock()
+		md := ctx.markdown
+		ctx.RUnlock()
+		start := time.Now()
+		buf, err = md.Render(buf, RenderOptions{
+			TOC:        sc.pageInfo.MDTOC,
+			LinkPrefix: strings.TrimSuffix(ctx.Link("/"), "/"),
+			Settings:   sc.pageInfo.Markdown,
+		})
+		if err != nil {
+			msg := fmt.Sprintf("error rendering %s: %v", file, err)
+			log.Println(msg)
+			return errors.New(msg)
 		}
-		hrender := blackfriday.NewHTMLRenderer(renderparams)
-		buf = blackfriday.Run(buf, blackfriday.WithExtensions(
-			blackfriday.CommonExtensions|
-				blackfriday.NoEmptyLineBeforeBlock|
-				blackfriday.AutoHeadingIDs|
-				blackfriday.Footnotes),
-			blackfriday.WithRenderer(hrender))
+		renderSeconds.Observe(time.Since(start).Seconds(), ctx.Name)
 	}
 
 	sc.Content = buf
@@ -395,43 +431,54 @@ func (host *Host) readContentFile(file string, defaults bool, sc *structuredCont
 	return nil
 }
 
-func chunk(value string) string {
-	if value == "" {
-		return "-"
-	}
-	return value
-}
-
 // ServeHTTP reads a request and creates an appropriate response.
 func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host.NewContext(w, req).Serve()
+}
+
+// Serve handles the request held in the context.
+func (ctx *Context) Serve() {
 	var localFile string
 
-	// TODO: Fix this non-critical race condition.  We need to save some
-	// variables in a per request basis, in particular the hostname. It may not
-	// always match the host name we gave to it (i.e: the "default" hostname). A
-	// per-request context would be useful.
-	host.Request = req
+	host := ctx.Host
+	req := ctx.Request
+	w := ctx.Response
 
 	// Settings default status as not found.
 	status := http.StatusNotFound
 
-	// Default size is no size (-1).
-	size := -1
-
 	// Requested path
 	reqpath := strings.TrimRight(req.URL.Path, "/")
 
-	// Stripping path
-	index := len(host.Path)
-
-	// If the hosts contains a path and the request begins with the same path, it
-	// is ignored for the matches.
-	if reqpath[0:index] == host.Path {
-		reqpath = reqpath[index:]
+	// If the host is mounted at a path and the request begins with the same
+	// path, it is ignored for the matches.
+	if reqpath == ctx.MountPath || strings.HasPrefix(reqpath, ctx.MountPath+"/") {
+		reqpath = reqpath[len(ctx.MountPath):]
 	}
 
 	reqpath = strings.TrimRight(reqpath, "/")
 
+	// Security headers apply to every response.
+	ctx.setHeaders(reqpath)
+
+	// Pages listen for changes in dev mode.
+	if reqpath == liveReloadPath {
+		host.RLock()
+		live := host.live != nil
+		host.RUnlock()
+		if live {
+			ctx.serveLiveReload()
+			return
+		}
+	}
+
+	// Moved pages are redirected before looking for files.
+	if ctx.redirect(reqpath) {
+		return
+	}
+
+	ctx.identify()
+
 	// Trying to match a file on webroot/
 	host.RLock()
 	webrootdir := to.String(host.Settings.Get("content", "webroot"))
@@ -453,16 +500,19 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		// File exists
 		if stat.IsDir() == false {
 			// Exists and it's not a directory, let's serve it.
+			if !ctx.authorize(reqpath, ctx.fileAccess(reqpath, "")) {
+				return
+			}
 			status = http.StatusOK // Changing status.
-			http.ServeFile(w, req, localFile)
-			size = int(stat.Size())
+			ctx.setCacheControl(reqpath)
+			ctx.serveFile(localFile, stat)
 		}
 	}
 
 	// Absolute document root.
 	var docroot string
 	if docroot, err = host.GetContentPath(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		ctx.serveError(http.StatusInternalServerError, err)
 		return
 	}
 
@@ -475,9 +525,12 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			if stat.IsDir() == false {
 				ext := path.Ext(directFile)
 				if ext != ".md" {
+					if !ctx.authorize(reqpath, ctx.fileAccess(reqpath, directFile)) {
+						return
+					}
 					status = http.StatusOK // Changing status.
-					http.ServeFile(w, req, directFile)
-					size = int(stat.Size())
+					ctx.setCacheControl(reqpath)
+					ctx.serveFile(directFile, stat)
 				}
 			}
 		}
@@ -497,23 +550,30 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 				// Let's not accept paths ending in "/".
 				if stat.IsDir() == false {
 					if strings.HasSuffix(req.URL.Path, "/") == true {
-						http.Redirect(w, req, "/"+host.Path+"/"+reqpath, 301)
+						http.Redirect(w, req, ctx.Link(reqpath), 301)
 						w.Write([]byte(http.StatusText(301)))
 						return
 					}
 				} else {
 					if strings.HasSuffix(req.URL.Path, "/") == false {
-						http.Redirect(w, req, req.URL.Path+"/", 301)
+						http.Redirect(w, req, ctx.Link(reqpath)+"/", 301)
 						w.Write([]byte(http.StatusText(301)))
 						return
 					}
 				}
 			}
 
+			// Rendering is what rate limits are for; the assets of a page
+			// don't count.
+			if ctx.Allow != nil && !ctx.Allow() {
+				return
+			}
+
 			// Creating a page.
 			p := &page.Page{}
 
-			p.BasePath = req.URL.Path
+			// Paths of pages are relative to the site, wherever it's mounted.
+			p.BasePath = "/" + strings.TrimLeft(reqpath, "/")
 
 			if stat != nil {
 				p.FilePath = localFile
@@ -540,20 +600,71 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 			// Read per-directory defaults
 			dfile, dstat := guessFile(p.FileDir+"_defaults", true)
+
+			// Pages other than search results can be validated without
+			// rendering them, and are served from memory when cached.
+			var etag string
+			var modified time.Time
+			var generation uint64
+			var access *Access
+			if stat != nil {
+				cached, hit := host.cache.get(ctx.cacheKey())
+				if hit {
+					access = cached.access
+				} else {
+					access = pageAccess(localFile, dfile)
+				}
+				if !ctx.authorize(reqpath, access) {
+					return
+				}
+				ctx.setCacheControl(reqpath)
+
+				// Pages with a nonce differ on every response, so they can't
+				// be revalidated.
+				if hit {
+					if ctx.Nonce != "" && cached.nonce != "" {
+						w.Write(ctx.withLiveReload(bytes.Replace(cached.body, []byte(cached.nonce), []byte(ctx.Nonce), -1)))
+					} else if !ctx.notModified(cached.etag, cached.modified) {
+						w.Write(ctx.withLiveReload(cached.body))
+					}
+					return
+				}
+				generation = host.cache.current()
+				if ctx.Nonce == "" {
+					etag, modified = ctx.pageValidators(p.FileDir, localFile, dfile)
+					if ctx.notModified(etag, modified) {
+						return
+					}
+				}
+			}
+
+			if stat == nil && !ctx.authorize(reqpath, ctx.fileAccess(reqpath, "")) {
+				return
+			}
+
 			if dstat != nil {
-				host.readContentFile(dfile, true, &content)
+				ctx.readContentFile(dfile, true, &content)
 			}
 
 			tpl := "index.tpl"
+			ht, err := ctx.templates()
+			if err != nil {
+				ctx.serveError(http.StatusInternalServerError, err)
+				return
+			}
 			host.RLock()
-			ht := host.TemplateGroup
 			p.Site = host.Settings
 			host.RUnlock()
 			p.Query = req.URL.Query()
-			p.Host = host
+			p.Host = ctx
+			p.User = ctx.User
+			p.Groups = ctx.Groups
+			p.Nonce = ctx.Nonce
+			p.URL = ctx.AbsoluteURL(reqpath)
+			p.BaseURL = ctx.AbsoluteURL("/")
 
 			if stat != nil {
-				err := host.readContentFile(localFile, false, &content)
+				err := ctx.readContentFile(localFile, false, &content)
 				if err == nil {
 					p.Content = template.HTML(content.Content)
 					p.TOC = content.pageInfo.MDTOC
@@ -564,14 +675,13 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 						}
 					}
 				}
-				if strings.Trim(host.Path, pathSeparator) == strings.Trim(req.URL.Path, pathSeparator) {
+				if strings.Trim(ctx.MountPath, pathSeparator) == strings.Trim(req.URL.Path, pathSeparator) {
 					p.IsHome = true
 				}
 			} else {
 				tpl = "search.tpl"
 				if t := ht.Lookup(tpl); t == nil {
-					http.Error(w, fmt.Sprintf("search.tpl not found"), http.StatusInternalServerError)
-					log.Printf("search.tpl not found for %s\n", host.Name)
+					ctx.serveError(http.StatusInternalServerError, errors.New("search.tpl not found"))
 					status = http.StatusInternalServerError
 				}
 			}
@@ -581,33 +691,33 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			p.CreateSideMenu()
 
 			if status != http.StatusInternalServerError {
-				if err = ht.ExecuteTemplate(w, tpl, p); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+				var out bytes.Buffer
+				if err = ht.ExecuteTemplate(&out, tpl, p); err != nil {
+					templateErrors.Inc(host.Name)
+					ctx.serveError(http.StatusInternalServerError, err)
 					status = http.StatusInternalServerError
 				} else {
 					status = http.StatusOK
+					if stat != nil {
+						host.cache.put(generation, &cachedPage{
+							key:      ctx.cacheKey(),
+							body:     out.Bytes(),
+							etag:     etag,
+							modified: modified,
+							access:   access,
+							nonce:    ctx.Nonce,
+						})
+					}
+					w.Write(ctx.withLiveReload(out.Bytes()))
 				}
 			}
 		}
 	}
 
 	if status == http.StatusNotFound {
-		http.Error(w, "Not found", http.StatusNotFound)
 		log.Printf("Path not found: %s\n", reqpath)
+		ctx.serveError(http.StatusNotFound, nil)
 	}
-
-	// Log line.
-	logLine := []string{
-		chunk(req.RemoteAddr),
-		chunk(""),
-		chunk(""),
-		chunk("[" + time.Now().Format("02/Jan/2006:15:04:05 -0700") + "]"),
-		chunk("\"" + fmt.Sprintf("%s %s %s", req.Method, req.RequestURI, req.Proto) + "\""),
-		chunk(fmt.Sprintf("%d", status)),
-		chunk(fmt.Sprintf("%d", size)),
-	}
-
-	fmt.Println(strings.Join(logLine, " "))
 }
 
 // loadTemplates loads templates with .tpl extension from the templates
@@ -631,10 +741,13 @@ func (host *Host) loadTemplates() error {
 	host.TemplateRoot = tplroot
 
 	t := template.New(host.Name).Funcs(host.funcMap)
-	wd, _ := os.Getwd()
-	tglob := path.Join(wd, tplroot, "*.tpl")
-	if _, err := t.ParseGlob(tglob); err != nil {
-		log.Printf("Error parsing templates for %s: %v\n", host.Name, err)
+	// Sites may live outside the working directory.
+	tabs, _ := filepath.Abs(tplroot)
+	tglob := path.Join(tabs, "*.tpl")
+	_, parseErr := t.ParseGlob(tglob)
+	if parseErr != nil {
+		templateErrors.Inc(host.Name)
+		log.Printf("Error parsing templates for %s: %v\n", host.Name, parseErr)
 	}
 	for _, tpl := range t.Templates() {
 		if tpl.Name() != host.Name {
@@ -642,11 +755,27 @@ func (host *Host) loadTemplates() error {
 		}
 	}
 	log.Printf("Loaded templates for %s from %s\n", host.Name, tplroot)
+
+	// Rendered pages are considered modified when any template is.
+	var modified time.Time
+	if files, err := filepath.Glob(tglob); err == nil {
+		for _, file := range files {
+			if stat, err := os.Stat(file); err == nil && stat.ModTime().After(modified) {
+				modified = stat.ModTime()
+			}
+		}
+	}
+
 	host.Lock()
 	host.TemplateGroup = t
+	host.templatesModified = modified
 	host.Unlock()
+	host.cache.invalidate()
 
 	if def := host.TemplateGroup.Lookup("index.tpl"); def == nil {
+		if parseErr != nil {
+			return fmt.Errorf("default Template %s could not be loaded: %v", "index.tpl", parseErr)
+		}
 		return fmt.Errorf("default Template %s could not be found", "index.tpl")
 	}
 
@@ -675,18 +804,52 @@ func (host *Host) fileWatcher() error {
 						return
 					}
 
+					// Content changes invalidate the render cache; new
+					// directories must be watched too.
+					if host.inContent(ev.Name) {
+						if ev.Op&fsnotify.Create != 0 {
+							if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+								host.watchTree(ev.Name)
+							}
+						}
+						host.cache.invalidate()
+						host.Lock()
+						host.aliasesStale = true
+						host.Unlock()
+						host.reloadPages()
+						continue
+					}
+
+					// Webroot changes only matter to live reload.
+					if host.inWebroot(ev.Name) {
+						if ev.Op&fsnotify.Create != 0 {
+							if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+								host.watchTree(ev.Name)
+							}
+						}
+						host.reloadPages()
+						continue
+					}
+
 					// Is settings file?
 					if strings.HasSuffix(ev.Name, settingsFile) {
 						log.Printf("%s: Reloading host settings %s...\n", host.Name, ev.Name)
+						Reloads.Inc(host.Name, "site")
 						err := host.loadSettings()
+						host.recordReload(err)
 
 						if err != nil {
 							log.Printf("%s: Could not reload host settings: %s\n", host.Name, path.Join(host.DocumentRoot, settingsFile))
+						} else {
+							host.watchContent()
+							host.reloadPages()
 						}
 					} else {
 						if strings.HasSuffix(ev.Name, ".tpl") == true {
 							log.Printf("%s: Reloading templates, %s changed", host.Name, ev.Name)
-							host.loadTemplates()
+							Reloads.Inc(host.Name, "templates")
+							host.recordReload(host.loadTemplates())
+							host.reloadPages()
 						}
 					}
 
@@ -711,7 +874,7 @@ func (host *Host) loadSettings() error {
 
 	file := path.Join(host.DocumentRoot, settingsFile)
 
-	_, err := os.Stat(file)
+	stat, err := os.Stat(file)
 
 	if err == nil {
 		if sdata, err := ioutil.ReadFile(file); err != nil {
@@ -725,10 +888,67 @@ func (host *Host) loadSettings() error {
 		return fmt.Errorf(`error trying to open settings file (%s): %q`, file, err)
 	}
 
+	// Optional access log for this host.
+	var logger *accesslog.Logger
+	var rules []cacheRule
+	if conf, ok := settings["access_log"].(map[string]interface{}); ok {
+		if logger, err = accesslog.New(conf); err != nil {
+			return fmt.Errorf(`configuring access log (%s): %q`, file, err)
+		}
+	}
+
+	cacheSize, err := loadRenderCacheSize(settings["render_cache"])
+	if err == nil {
+		rules, err = loadCacheRules(settings["cache_control"])
+	}
+	var redirects []*Redirect
+	if err == nil {
+		redirects, err = loadRedirects(settings["redirects"])
+	}
+	var auth *authConfig
+	if err == nil {
+		auth, err = loadAuth(host.DocumentRoot, settings["auth"])
+	}
+	var headers *headerPolicy
+	if err == nil {
+		headers, err = loadHeaders(settings["headers"])
+	}
+	var base *url.URL
+	if err == nil {
+		base, err = parseBaseURL(to.String(settings["baseURL"]))
+	}
+	var md MarkdownRenderer
+	if err == nil {
+		md, err = loadMarkdown(settings["markdown"])
+	}
+	if err != nil {
+		if logger != nil {
+			logger.Close()
+		}
+		return fmt.Errorf(`reading settings file (%s): %q`, file, err)
+	}
+
 	host.Lock()
 	host.Settings = &settings
+	previous := host.AccessLog
+	host.AccessLog = logger
+	host.cacheRules = rules
+	host.redirects = redirects
+	host.auth = auth
+	host.headers = headers
+	host.baseURL = base
+	host.markdown = md
+	host.aliasesStale = true
+	host.settingsModified = stat.ModTime()
 	host.Unlock()
 
+	host.cache.setLimit(cacheSize)
+	host.cache.invalidate()
+
+	if previous != nil {
+		previous.Close()
+	}
+
 	return nil
 }
 
@@ -757,12 +977,16 @@ func New(name string, root string) (*Host, error) {
 		Path:         strings.TrimRight(route, "/"),
 		DocumentRoot: root,
 		RWMutex:      new(sync.RWMutex),
+		cache:        newRenderCache(),
+		aliasesStale: true,
 	}
 
+	// Functions that depend on the request are bound per request by
+	// Context.templates; these entries only need to exist for parsing.
 	host.funcMap = template.FuncMap{
-		"url":    func(s string) string { return host.url(s) },
-		"anchor": func(a, b string) template.HTML { return host.anchor(a, b) },
-		"asset":  func(s string) string { return host.asset(s) },
+		"url":    func(s string) string { return s },
+		"anchor": func(a, b string) template.HTML { return template.HTML("") },
+		"asset":  func(s string) string { return s },
 		"getint": getInt,
 		"include": func(f string) string {
 			s, err := readRawFile(path.Join(host.DocumentRoot, f))
@@ -778,10 +1002,21 @@ func New(name string, root string) (*Host, error) {
 	}
 
 	// Watcher
-	host.fileWatcher()
+	if err = host.fileWatcher(); err != nil {
+		log.Printf("Could not start host: %s\n", name)
+		return nil, err
+	}
+	// Callers only close hosts that loaded, so a host that fails closes its
+	// watcher and access log itself.
+	loaded := false
+	defer func() {
+		if !loaded {
+			host.Close()
+		}
+	}()
+
 	// Watch settings file
-	wd, _ := os.Getwd()
-	sf := path.Join(wd, host.DocumentRoot, settingsFile)
+	sf, _ := filepath.Abs(path.Join(host.DocumentRoot, settingsFile))
 	host.Watcher.Add(sf)
 
 	// Loading host settings
@@ -802,11 +1037,17 @@ func New(name string, root string) (*Host, error) {
 		tpldir = "templates"
 	}
 
-	td := path.Join(wd, host.DocumentRoot, tpldir)
+	td, _ := filepath.Abs(path.Join(host.DocumentRoot, tpldir))
 	host.Watcher.Add(td)
 
+	// Watch content for the render cache.
+	host.watchContent()
+
+	host.recordReload(nil)
+
 	log.Printf("Routing: %s -> %s\n", name, root)
 
+	loaded = true
 	return host, nil
 
 }
@@ -0,0 +1,115 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newTestHost creates a host named name for a site made of files, as
+// written by writeSite.
+func newTestHost(t *testing.T, name string, files map[string]string) *Host {
+	t.Helper()
+	host, err := New(name, writeSite(t, files))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(host.Close)
+	return host
+}
+
+// writeSite creates a temporary site made of files, given by their path below
+// the site's root, and returns its root. The site gets a minimal site.yaml and
+// index.tpl unless files has its own.
+func writeSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	site := map[string]string{
+		"site.yaml":           "title: Test\n",
+		"templates/index.tpl": "{{ .Content }}",
+	}
+	for file, content := range files {
+		site[file] = content
+	}
+	for file, content := range site {
+		full := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// get serves a GET request for target and returns the response.
+func get(host *Host, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	host.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+// openFiles returns the number of files the process has open, or -1 where
+// that can't be told.
+func openFiles() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+func TestNewBrokenSite(t *testing.T) {
+	// The access log opens with the settings, before the missing templates
+	// directory fails the host.
+	root := writeSite(t, map[string]string{
+		"site.yaml": "access_log:\n  file: \"access.log\"\ncontent:\n  templates: \"missing\"\n",
+	})
+
+	files, goroutines := openFiles(), runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if host, err := New("localhost", root); err == nil {
+			host.Close()
+			t.Fatal("New loaded a site without templates")
+		}
+	}
+
+	// Watcher goroutines end shortly after the watcher is closed.
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines left running by hosts that failed to load", n-goroutines)
+	}
+	if n := openFiles(); n > files {
+		t.Errorf("%d files left open by hosts that failed to load", n-files)
+	}
+}
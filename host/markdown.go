@@ -0,0 +1,359 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lnxjedi/to"
+	"github.com/russross/blackfriday"
+)
+
+// MarkdownRenderer turns the markdown of pages into HTML. A site picks one
+// with the engine setting of the markdown section of its site.yaml.
+type MarkdownRenderer interface {
+	// Render returns the HTML for the markdown of a page.
+	Render(source []byte, opts RenderOptions) ([]byte, error)
+}
+
+// RenderOptions are the settings of a single page.
+type RenderOptions struct {
+	// Generate a table of contents, from MDTOC in the frontmatter.
+	TOC bool
+	// Prefix for root-relative links and images, so that they point inside
+	// the site wherever it's mounted; empty for sites at the root.
+	LinkPrefix string
+	// Markdown settings from the frontmatter of the page, overriding those
+	// of site.yaml.
+	Settings map[string]interface{}
+}
+
+// MarkdownEngine creates a renderer from the markdown section of site.yaml.
+type MarkdownEngine func(conf map[string]interface{}) (MarkdownRenderer, error)
+
+// Engine used when site.yaml doesn't name one.
+const defaultMarkdownEngine = "blackfriday"
+
+var (
+	markdownEngines     = map[string]MarkdownEngine{}
+	markdownEnginesLock sync.RWMutex
+)
+
+// RegisterMarkdown makes a markdown engine available to sites under name.
+func RegisterMarkdown(name string, engine MarkdownEngine) {
+	markdownEnginesLock.Lock()
+	markdownEngines[name] = engine
+	markdownEnginesLock.Unlock()
+}
+
+func init() {
+	RegisterMarkdown("blackfriday", newBlackfriday)
+	RegisterMarkdown("commonmark", newCommonMark)
+}
+
+// loadMarkdown creates the renderer for the markdown section of site.yaml.
+func loadMarkdown(v interface{}) (MarkdownRenderer, error) {
+	conf, _ := v.(map[string]interface{})
+	name := to.String(conf["engine"])
+	if name == "" {
+		name = defaultMarkdownEngine
+	}
+
+	markdownEnginesLock.RLock()
+	engine, ok := markdownEngines[name]
+	var names []string
+	for known := range markdownEngines {
+		names = append(names, known)
+	}
+	markdownEnginesLock.RUnlock()
+
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown markdown engine %s, expected one of: %s", name, strings.Join(names, ", "))
+	}
+	r, err := engine(conf)
+	if err != nil {
+		return nil, fmt.Errorf("markdown engine %s: %v", name, err)
+	}
+	return r, nil
+}
+
+// prefixLink returns the destination of a link or image with prefix added
+// when it's root-relative.
+func prefixLink(dest []byte, prefix string) []byte {
+	if prefix == "" || len(dest) == 0 || dest[0] != '/' || (len(dest) > 1 && dest[1] == '/') {
+		return dest
+	}
+	return append([]byte(prefix), dest...)
+}
+
+// markdownSettings returns the markdown settings of site.yaml with those of
+// a page applied over them.
+func markdownSettings(site, page map[string]interface{}) map[string]interface{} {
+	if len(page) == 0 {
+		return site
+	}
+	merged := make(map[string]interface{}, len(site)+len(page))
+	for key, v := range site {
+		merged[key] = v
+	}
+	for key, v := range page {
+		merged[key] = v
+	}
+	return merged
+}
+
+// boolSetting returns a markdown setting that turns something on or off, or
+// def when it isn't set.
+func boolSetting(conf map[string]interface{}, key string, def bool) bool {
+	if v, ok := conf[key]; ok && v != nil {
+		return to.Bool(v)
+	}
+	return def
+}
+
+// checkMarkdownSettings returns an error naming the settings an engine
+// doesn't know, so that typos don't go unnoticed.
+func checkMarkdownSettings(conf map[string]interface{}, known func(key string) bool) error {
+	var unknown []string
+	for key := range conf {
+		if key != "engine" && !known(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown markdown settings: %s", strings.Join(unknown, ", "))
+}
+
+// blackfridayFlag is a setting of the blackfriday engine, turning on either
+// a parser extension or an HTML renderer flag.
+type blackfridayFlag struct {
+	ext  blackfriday.Extensions
+	html blackfriday.HTMLFlags
+	// Whether it's on when not set.
+	on bool
+}
+
+// Settings of the blackfriday engine that site.yaml and frontmatter can turn
+// on and off. The defaults are the extensions and flags luminos has always
+// rendered with.
+var blackfridayFlags = map[string]blackfridayFlag{
+	"no_intra_emphasis":          {ext: blackfriday.NoIntraEmphasis, on: true},
+	"tables":                     {ext: blackfriday.Tables, on: true},
+	"fenced_code":                {ext: blackfriday.FencedCode, on: true},
+	"autolink":                   {ext: blackfriday.Autolink, on: true},
+	"strikethrough":              {ext: blackfriday.Strikethrough, on: true},
+	"space_headings":             {ext: blackfriday.SpaceHeadings, on: true},
+	"heading_ids":                {ext: blackfriday.HeadingIDs, on: true},
+	"auto_heading_ids":           {ext: blackfriday.AutoHeadingIDs, on: true},
+	"backslash_line_break":       {ext: blackfriday.BackslashLineBreak, on: true},
+	"definition_lists":           {ext: blackfriday.DefinitionLists, on: true},
+	"no_empty_line_before_block": {ext: blackfriday.NoEmptyLineBeforeBlock, on: true},
+	"footnotes":                  {ext: blackfriday.Footnotes, on: true},
+	"hard_wraps":                 {ext: blackfriday.HardLineBreak},
+	"footnote_return_links":      {html: blackfriday.FootnoteReturnLinks, on: true},
+	"skip_html":                  {html: blackfriday.SkipHTML},
+	"smartypants":                {html: blackfriday.Smartypants},
+	"smartypants_fractions":      {html: blackfriday.SmartypantsFractions},
+	"smartypants_dashes":         {html: blackfriday.SmartypantsDashes},
+	"smartypants_latex_dashes":   {html: blackfriday.SmartypantsLatexDashes},
+	"smartypants_angled_quotes":  {html: blackfriday.SmartypantsAngledQuotes},
+	"smartypants_quotes_nbsp":    {html: blackfriday.SmartypantsQuotesNBSP},
+	"target_blank":               {html: blackfriday.HrefTargetBlank},
+	"nofollow":                   {html: blackfriday.NofollowLinks},
+	"noreferrer":                 {html: blackfriday.NoreferrerLinks},
+}
+
+func blackfridaySetting(key string) bool {
+	_, ok := blackfridayFlags[key]
+	return ok || key == "heading_id_prefix" || key == "highlight" || key == "line_numbers"
+}
+
+// blackfridayRenderer renders markdown with blackfriday, the engine luminos
+// has always used.
+type blackfridayRenderer struct {
+	conf map[string]interface{}
+}
+
+func newBlackfriday(conf map[string]interface{}) (MarkdownRenderer, error) {
+	if err := checkMarkdownSettings(conf, blackfridaySetting); err != nil {
+		return nil, err
+	}
+	return blackfridayRenderer{conf: conf}, nil
+}
+
+// Render renders markdown with the extensions and HTML flags turned on by
+// site.yaml and the page.
+func (r blackfridayRenderer) Render(source []byte, opts RenderOptions) ([]byte, error) {
+	if err := checkMarkdownSettings(opts.Settings, blackfridaySetting); err != nil {
+		return nil, err
+	}
+	conf := markdownSettings(r.conf, opts.Settings)
+
+	var extensions blackfriday.Extensions
+	var htmlflags blackfriday.HTMLFlags
+	for key, flag := range blackfridayFlags {
+		if boolSetting(conf, key, flag.on) {
+			extensions |= flag.ext
+			htmlflags |= flag.html
+		}
+	}
+	if opts.TOC {
+		htmlflags |= blackfriday.TOC
+	}
+	renderparams := blackfriday.HTMLRendererParameters{
+		Flags:           htmlflags,
+		HeadingIDPrefix: to.String(conf["heading_id_prefix"]),
+	}
+	hrender := &pageRenderer{
+		HTMLRenderer: blackfriday.NewHTMLRenderer(renderparams),
+		prefix:       opts.LinkPrefix,
+		highlight:    boolSetting(conf, "highlight", true),
+		lineNumbers:  boolSetting(conf, "line_numbers", false),
+	}
+	return blackfriday.Run(joinFenceOptions(source), blackfriday.WithExtensions(extensions),
+		blackfriday.WithRenderer(hrender)), nil
+}
+
+// fenceLine matches the fence that opens or closes a code block, and its
+// info string.
+var fenceLine = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*([^\n]*?)[ \t]*$")
+
+// joinFenceOptions rewrites the opening fences of code blocks like
+// "```go {linenos, 3-5}" as "```go{linenos,3-5}", since blackfriday doesn't
+// take fences with more than one word after them for code blocks.
+func joinFenceOptions(source []byte) []byte {
+	if !bytes.Contains(source, []byte("{")) {
+		return source
+	}
+	lines := bytes.SplitAfter(source, []byte("\n"))
+	open := ""
+	for i, line := range lines {
+		m := fenceLine.FindSubmatch(bytes.TrimRight(line, "\r\n"))
+		if m == nil {
+			continue
+		}
+		marker, info := string(m[1]), string(m[2])
+		if open != "" {
+			// Fences with an info string don't close blocks.
+			if info == "" && marker[0] == open[0] && len(marker) >= len(open) {
+				open = ""
+			}
+			continue
+		}
+		open = marker
+		start, end := strings.Index(info, "{"), strings.LastIndex(info, "}")
+		if start < 0 || end < start || strings.TrimSpace(info[end+1:]) != "" {
+			continue
+		}
+		options := strings.Fields(strings.Replace(info[start+1:end], ",", " ", -1))
+		joined := strings.TrimSpace(info[:start]) + "{" + strings.Join(options, ",") + "}"
+		eol := line[len(bytes.TrimRight(line, "\r\n")):]
+		lines[i] = append([]byte(line[:bytes.Index(line, m[2])]), append([]byte(joined), eol...)...)
+	}
+	return bytes.Join(lines, nil)
+}
+
+// pageRenderer renders markdown like the HTML renderer, prefixing the
+// root-relative links and images of pages with the path the site is
+// published under, and highlighting fenced code.
+type pageRenderer struct {
+	*blackfriday.HTMLRenderer
+	prefix string
+	// Highlight fenced code, numbering the lines of blocks that don't turn
+	// numbers on or off themselves when lineNumbers is set.
+	highlight   bool
+	lineNumbers bool
+}
+
+// RenderNode rewrites the destination of links and images before rendering
+// them.
+func (r *pageRenderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	if entering && (node.Type == blackfriday.Link || node.Type == blackfriday.Image) {
+		node.LinkData.Destination = prefixLink(node.LinkData.Destination, r.prefix)
+	}
+	if node.Type == blackfriday.CodeBlock && node.IsFenced {
+		if r.highlight {
+			w.Write(highlightCode(string(node.Info), node.Literal, r.lineNumbers))
+			return blackfriday.GoToNext
+		}
+		// The options of the block aren't part of its language.
+		lang, _ := parseCodeInfo(string(node.Info), false)
+		node.Info = []byte(lang)
+	}
+	return r.HTMLRenderer.RenderNode(w, node, entering)
+}
+
+// RenderHeader writes the table of contents, if any, pointing at headings
+// with the ID prefix added, which blackfriday leaves out of its links.
+func (r *pageRenderer) RenderHeader(w io.Writer, ast *blackfriday.Node) {
+	if r.HeadingIDPrefix == "" {
+		r.HTMLRenderer.RenderHeader(w, ast)
+		return
+	}
+	var buf bytes.Buffer
+	r.HTMLRenderer.RenderHeader(&buf, ast)
+	w.Write(bytes.Replace(buf.Bytes(), []byte(`href="#toc_`), []byte(`href="#`+r.HeadingIDPrefix+`toc_`), -1))
+}
+
+// tocList writes a table of contents as nested lists, like the one of
+// blackfriday. Headings are given as their level, ID and HTML text.
+type tocList struct {
+	bytes.Buffer
+	levels []int
+}
+
+// add writes a heading, opening and closing lists as its level changes.
+func (t *tocList) add(level int, id, text string) {
+	if len(t.levels) == 0 || level > t.levels[len(t.levels)-1] {
+		t.WriteString("\n<ul>\n")
+		t.levels = append(t.levels, level)
+	} else {
+		for len(t.levels) > 1 && level < t.levels[len(t.levels)-1] {
+			t.WriteString("</li>\n</ul>")
+			t.levels = t.levels[:len(t.levels)-1]
+		}
+		t.WriteString("</li>\n")
+	}
+	fmt.Fprintf(t, `<li><a href="#%s">%s</a>`, id, text)
+}
+
+// html returns the table of contents in a <nav>, or nothing when there are
+// no headings.
+func (t *tocList) html() []byte {
+	if len(t.levels) == 0 {
+		return nil
+	}
+	for range t.levels {
+		t.WriteString("</li>\n</ul>\n")
+	}
+	return append(append([]byte("<nav>\n"), bytes.TrimPrefix(t.Bytes(), []byte("\n"))...), "</nav>\n\n"...)
+}
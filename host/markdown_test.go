@@ -0,0 +1,161 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderMarkdown renders source with the engine and settings of conf.
+func renderMarkdown(t *testing.T, conf map[string]interface{}, source string, opts RenderOptions) string {
+	t.Helper()
+	md, err := loadMarkdown(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := md.Render([]byte(source), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestPrefixLink(t *testing.T) {
+	tests := []struct {
+		dest, prefix, want string
+	}{
+		{"/page", "/handbook", "/handbook/page"},
+		{"/", "/handbook", "/handbook/"},
+		{"/page", "", "/page"},
+		{"page", "/handbook", "page"},
+		{"../page", "/handbook", "../page"},
+		{"#section", "/handbook", "#section"},
+		{"//cdn.example.org/x", "/handbook", "//cdn.example.org/x"},
+		{"https://example.org/page", "/handbook", "https://example.org/page"},
+		{"mailto:docs@example.org", "/handbook", "mailto:docs@example.org"},
+		{"", "/handbook", ""},
+	}
+	for _, test := range tests {
+		if got := string(prefixLink([]byte(test.dest), test.prefix)); got != test.want {
+			t.Errorf("prefixLink(%q, %q) = %q, want %q", test.dest, test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestMarkdownLinkPrefix(t *testing.T) {
+	source := []byte("[page](/page) ![logo](/img/logo.png) [top](#top) [site](https://example.org/page) [cdn](//cdn.example.org/x) [rel](other)\n")
+	for _, engine := range []string{"blackfriday", "commonmark"} {
+		md, err := loadMarkdown(map[string]interface{}{"engine": engine})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, prefix := range []string{"", "/handbook"} {
+			out, err := md.Render(source, RenderOptions{LinkPrefix: prefix})
+			if err != nil {
+				t.Fatalf("%s: %v", engine, err)
+			}
+			html := string(out)
+			for _, want := range []string{
+				`href="` + prefix + `/page"`,
+				`src="` + prefix + `/img/logo.png"`,
+				`href="#top"`,
+				`href="https://example.org/page"`,
+				`href="//cdn.example.org/x"`,
+				`href="other"`,
+			} {
+				if !strings.Contains(html, want) {
+					t.Errorf("%s with prefix %q: missing %s in %s", engine, prefix, want, html)
+				}
+			}
+		}
+	}
+}
+
+func TestBlackfridaySettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		conf   map[string]interface{}
+		source string
+		want   string
+		absent string
+	}{
+		{"tables", nil, "| a | b |\n|---|---|\n| 1 | 2 |\n", "<table>", ""},
+		{"no tables", map[string]interface{}{"tables": false}, "| a | b |\n|---|---|\n| 1 | 2 |\n", "<p>| a | b |", "<table>"},
+		{"hard wraps", map[string]interface{}{"hard_wraps": true}, "one\ntwo\n", "one<br>\ntwo", ""},
+		{"skip html", map[string]interface{}{"skip_html": true}, "<div>raw</div>\n\ntext\n", "<p>text</p>", "<div>"},
+		{"heading id prefix", map[string]interface{}{"heading_id_prefix": "doc-"}, "# Intro\n", `<h1 id="doc-intro">Intro</h1>`, ""},
+		{"external links", map[string]interface{}{"target_blank": true, "nofollow": true}, "[out](https://example.org)\n",
+			`<a href="https://example.org" target="_blank" rel="nofollow">out</a>`, ""},
+		// Without footnotes, the note is read as a link definition.
+		{"no footnotes", map[string]interface{}{"footnotes": false}, "Text[^1].\n\n[^1]: Note.\n", `<a href="Note.">^1</a>`, "footnotes"},
+	}
+	for _, test := range tests {
+		got := renderMarkdown(t, test.conf, test.source, RenderOptions{})
+		if !strings.Contains(got, test.want) || (test.absent != "" && strings.Contains(got, test.absent)) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPageMarkdownSettings(t *testing.T) {
+	for _, engine := range []string{"blackfriday", "commonmark"} {
+		md, err := loadMarkdown(map[string]interface{}{"engine": engine, "hard_wraps": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		render := func(settings map[string]interface{}) (string, error) {
+			out, err := md.Render([]byte("one\ntwo\n"), RenderOptions{Settings: settings})
+			return string(out), err
+		}
+
+		// Pages override the settings of the site, for themselves only.
+		if got, _ := render(map[string]interface{}{"hard_wraps": false}); strings.Contains(got, "<br") {
+			t.Errorf("%s: page settings were ignored: %q", engine, got)
+		}
+		if got, _ := render(nil); !strings.Contains(got, "<br") {
+			t.Errorf("%s: site settings were lost: %q", engine, got)
+		}
+		if _, err := render(map[string]interface{}{"hardwraps": true}); err == nil {
+			t.Errorf("%s: unknown page setting was accepted", engine)
+		}
+	}
+}
+
+func TestPageMarkdownFrontmatter(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"site.yaml":           "title: Test\nmarkdown:\n  hard_wraps: true\n",
+		"content/index.md":    "one\ntwo\n",
+		"content/page.md":     "---\n#luminos\nMarkdown: { hard_wraps: false }\n---\none\ntwo\n",
+		"content/typo.md":     "---\n#luminos\nMarkdown: { hardwraps: false }\n---\none\ntwo\n",
+		"templates/index.tpl": "[{{ .Content }}]",
+	})
+	if body := get(host, "/").Body.String(); !strings.Contains(body, "<br") {
+		t.Errorf("home page = %q, want line breaks", body)
+	}
+	if body := get(host, "/page").Body.String(); strings.Contains(body, "<br") {
+		t.Errorf("page = %q, want no line breaks", body)
+	}
+	if body := get(host, "/typo").Body.String(); strings.Contains(body, "one") {
+		t.Errorf("page with an unknown setting = %q, want it left out", body)
+	}
+}
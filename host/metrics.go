@@ -0,0 +1,40 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"github.com/lnxjedi/luminos/metrics"
+)
+
+var (
+	renderSeconds = metrics.NewHistogramVec("luminos_markdown_render_seconds",
+		"Time spent rendering markdown to HTML.", nil, "host")
+	templateErrors = metrics.NewCounterVec("luminos_template_errors_total",
+		"Templates that failed to parse or execute.", "host")
+	searchQueries = metrics.NewCounterVec("luminos_search_queries_total",
+		"Search queries.", "host")
+	searchSeconds = metrics.NewHistogramVec("luminos_search_duration_seconds",
+		"Time spent answering search queries.", nil, "host")
+	// Reloads is also used for settings.yaml reloads, with an empty host.
+	Reloads = metrics.NewCounterVec("luminos_reloads_total",
+		"Reloads triggered by file changes or signals, by what was reloaded.", "host", "kind")
+)
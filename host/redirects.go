@@ -0,0 +1,302 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/to"
+)
+
+// Kinds of redirects.
+const (
+	RedirectExact  = "exact"
+	RedirectPrefix = "prefix"
+	RedirectRegex  = "regex"
+	RedirectAlias  = "alias"
+)
+
+// Minimum time between scans of the content tree for aliases, which are
+// triggered by requests after content changed.
+const aliasScanInterval = 2 * time.Second
+
+// Redirect describes an effective redirect of a host.
+type Redirect struct {
+	// One of exact, prefix, regex or alias.
+	Kind string
+	// Path, prefix or regular expression matched against the request path
+	// below the host's mount path.
+	From string
+	// Target of the redirect; "$1" and the like expand regex captures.
+	To string
+	// HTTP status of the redirect.
+	Status int
+	// Content file that declares an alias.
+	Source string
+
+	re *regexp.Regexp
+}
+
+// target returns the redirect target for a request path, if it matches.
+func (r *Redirect) target(p string) (string, bool) {
+	switch r.Kind {
+	case RedirectPrefix:
+		if p == r.From || strings.HasPrefix(p, r.From+"/") {
+			return strings.TrimRight(r.To, "/") + p[len(r.From):], true
+		}
+	case RedirectRegex:
+		if m := r.re.FindStringSubmatchIndex(p); m != nil {
+			return string(r.re.ExpandString(nil, r.To, p, m)), true
+		}
+	default:
+		if p == r.From {
+			return r.To, true
+		}
+	}
+	return "", false
+}
+
+// loops returns true if the target of a prefix redirect matches the prefix
+// itself, so that every redirected request would be redirected again.
+func (r *Redirect) loops() bool {
+	if isExternalTarget(r.To) {
+		return false
+	}
+	u, err := url.Parse(r.To)
+	if err != nil {
+		return false
+	}
+	target := cleanPath(u.Path)
+	return r.From == "/" || target == r.From || strings.HasPrefix(target, r.From+"/")
+}
+
+// isExternalTarget returns true if a redirect target is on another host,
+// given with a scheme or protocol-relative.
+func isExternalTarget(to string) bool {
+	return isExternalLink(to) || strings.HasPrefix(to, "//")
+}
+
+// cleanPath normalizes a path to start with a single "/" and have no
+// trailing "/".
+func cleanPath(p string) string {
+	return "/" + strings.Trim(p, "/")
+}
+
+// loadRedirects reads the "redirects" list from site.yaml.
+func loadRedirects(conf interface{}) ([]*Redirect, error) {
+	list, ok := conf.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	redirects := make([]*Redirect, 0, len(list))
+	for i, item := range list {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("redirect %d is not a map", i+1)
+		}
+
+		r := &Redirect{To: to.String(entry["to"]), Status: http.StatusMovedPermanently}
+		if r.To == "" {
+			return nil, fmt.Errorf("redirect %d has no target", i+1)
+		}
+
+		switch {
+		case entry["from"] != nil:
+			r.Kind, r.From = RedirectExact, cleanPath(to.String(entry["from"]))
+		case entry["prefix"] != nil:
+			r.Kind, r.From = RedirectPrefix, cleanPath(to.String(entry["prefix"]))
+		case entry["regex"] != nil:
+			r.Kind, r.From = RedirectRegex, to.String(entry["regex"])
+			var err error
+			if r.re, err = regexp.Compile(r.From); err != nil {
+				return nil, fmt.Errorf("redirect %d: %v", i+1, err)
+			}
+		default:
+			return nil, fmt.Errorf("redirect %d needs one of from, prefix or regex", i+1)
+		}
+
+		if r.Kind == RedirectPrefix && r.loops() {
+			return nil, fmt.Errorf("redirect %d: target %s is below prefix %s, so its redirects would be redirected again", i+1, r.To, r.From)
+		}
+
+		if v, ok := entry["status"]; ok {
+			switch r.Status = int(to.Int64(v)); r.Status {
+			case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+				http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			default:
+				return nil, fmt.Errorf("redirect %d: unsupported status %v", i+1, v)
+			}
+		}
+
+		redirects = append(redirects, r)
+	}
+	return redirects, nil
+}
+
+// Redirects returns the redirects of the host in the order they are tried:
+// those from site.yaml first, then the aliases of pages.
+func (host *Host) Redirects() []Redirect {
+	var list []Redirect
+	host.RLock()
+	for _, r := range host.redirects {
+		list = append(list, *r)
+	}
+	host.RUnlock()
+	for _, r := range host.aliases() {
+		list = append(list, *r)
+	}
+	return list
+}
+
+// aliases returns the redirects declared by the Aliases of content files,
+// scanning the content tree again after it changed, at most once every
+// aliasScanInterval.
+func (host *Host) aliases() []*Redirect {
+	host.Lock()
+	if !host.aliasesStale || time.Since(host.aliasesScanned) < aliasScanInterval {
+		aliases := host.aliasList
+		host.Unlock()
+		return aliases
+	}
+	// Changes made during the scan mark the list stale again.
+	host.aliasesStale = false
+	host.aliasesScanned = time.Now()
+	host.Unlock()
+
+	aliases := host.scanAliases()
+
+	host.Lock()
+	host.aliasList = aliases
+	host.Unlock()
+	return aliases
+}
+
+// scanAliases reads the frontmatter of every content file for Aliases.
+func (host *Host) scanAliases() []*Redirect {
+	root, err := host.GetContentPath()
+	if err != nil {
+		return nil
+	}
+
+	seen := map[string]string{}
+	var aliases []*Redirect
+
+	filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := info.Name()
+		if file != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || strings.HasSuffix(name, ".tpl") {
+			return nil
+		}
+
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil
+		}
+		var fm frontMatter
+		if _, err := parseFrontMatter(file, buf, false, &fm); err != nil || len(fm.Aliases) == 0 {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, file)
+		target := pageURL(filepath.ToSlash(rel))
+		for _, alias := range fm.Aliases {
+			alias = cleanPath(alias)
+			if other, ok := seen[alias]; ok {
+				log.Printf("%s: alias %s of %s is already used by %s\n", host.Name, alias, rel, other)
+				continue
+			}
+			seen[alias] = rel
+			aliases = append(aliases, &Redirect{
+				Kind:   RedirectAlias,
+				From:   alias,
+				To:     target,
+				Status: http.StatusMovedPermanently,
+				Source: rel,
+			})
+		}
+		return nil
+	})
+
+	sort.SliceStable(aliases, func(i, j int) bool { return aliases[i].From < aliases[j].From })
+	return aliases
+}
+
+// pageURL returns the path a content file is served at.
+func pageURL(rel string) string {
+	for _, ext := range extensions {
+		if strings.HasSuffix(rel, ext) {
+			rel = strings.TrimSuffix(rel, ext)
+			break
+		}
+	}
+	if path.Base(rel) == "index" {
+		rel = path.Dir(rel)
+	}
+	return cleanPath(rel)
+}
+
+// redirect sends a redirect when reqpath, the request path below the mount
+// path, matches a redirect from site.yaml or a page alias.
+func (ctx *Context) redirect(reqpath string) bool {
+	p := cleanPath(reqpath)
+
+	ctx.RLock()
+	redirects := ctx.redirects
+	ctx.RUnlock()
+
+	for _, list := range [][]*Redirect{redirects, ctx.aliases()} {
+		for _, r := range list {
+			target, ok := r.target(p)
+			if !ok || target == p {
+				continue
+			}
+			if !isExternalTarget(target) {
+				target = ctx.Link(target)
+			}
+			if q := ctx.Request.URL.RawQuery; q != "" && !strings.Contains(target, "?") {
+				target += "?" + q
+			}
+			http.Redirect(ctx.Response, ctx.Request, target, r.Status)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadRedirectsLoops(t *testing.T) {
+	tests := []struct {
+		prefix, to string
+		loops      bool
+	}{
+		{"/docs", "/docs/v2", true},
+		{"/docs", "/docs", true},
+		{"/docs/", "docs/v2/", true},
+		{"/docs", "/docs/v2?from=old", true},
+		{"/", "/home", true},
+		{"/docs", "/documentation", false},
+		{"/docs/v1", "/docs/v2", false},
+		{"/docs", "/", false},
+		{"/docs", "https://example.org/docs/v2", false},
+		{"/docs", "//docs.example.org/docs", false},
+	}
+	for _, test := range tests {
+		conf := []interface{}{map[string]interface{}{"prefix": test.prefix, "to": test.to}}
+		_, err := loadRedirects(conf)
+		if test.loops && err == nil {
+			t.Errorf("prefix %s to %s was accepted, but loops", test.prefix, test.to)
+		}
+		if !test.loops && err != nil {
+			t.Errorf("prefix %s to %s: %v", test.prefix, test.to, err)
+		}
+	}
+}
+
+func TestRedirectTarget(t *testing.T) {
+	conf := []interface{}{
+		map[string]interface{}{"from": "/about-us", "to": "/about"},
+		map[string]interface{}{"prefix": "/guides", "to": "/docs/"},
+		map[string]interface{}{"regex": "^/blog/([0-9]+)/(.*)$", "to": "/posts/$1-$2"},
+	}
+	redirects, err := loadRedirects(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		p, want string
+	}{
+		{"/about-us", "/about"},
+		{"/about-us/team", ""},
+		{"/guides", "/docs"},
+		{"/guides/install", "/docs/install"},
+		{"/guidesx", ""},
+		{"/blog/2018/hello", "/posts/2018-hello"},
+	}
+	for _, test := range tests {
+		got := ""
+		for _, r := range redirects {
+			if target, ok := r.target(test.p); ok {
+				got = target
+				break
+			}
+		}
+		if got != test.want {
+			t.Errorf("redirect of %s = %q, want %q", test.p, got, test.want)
+		}
+	}
+}
+
+func TestRedirectExternalTargets(t *testing.T) {
+	host := newTestHost(t, "localhost/handbook", map[string]string{
+		"site.yaml": `redirects:
+  - { from: "/old", to: "//docs.example.org/new" }
+  - { prefix: "/api", to: "//api.example.org/v2" }
+  - { from: "/home", to: "https://example.org/" }
+  - { from: "/about-us", to: "/about" }
+`,
+	})
+	tests := []struct {
+		target, location string
+	}{
+		{"/handbook/old", "//docs.example.org/new"},
+		{"/handbook/api/users", "//api.example.org/v2/users"},
+		{"/handbook/home", "https://example.org/"},
+		{"/handbook/about-us", "/handbook/about"},
+	}
+	for _, test := range tests {
+		w := get(host, test.target)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("GET %s = %d, want 301", test.target, w.Code)
+			continue
+		}
+		if loc := w.Header().Get("Location"); loc != test.location {
+			t.Errorf("GET %s redirected to %q, want %q", test.target, loc, test.location)
+		}
+	}
+}
+
+func TestAliasScanThrottled(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"content/a.md": "---\n#luminos\nAliases: [\"/old-a\"]\n---\n# A\n",
+	})
+	if w := get(host, "/old-a"); w.Code != http.StatusMovedPermanently {
+		t.Fatalf("GET /old-a = %d, want 301", w.Code)
+	}
+
+	// Pages added right after a scan aren't seen until the interval passed.
+	docroot, _ := host.GetContentPath()
+	page := "---\n#luminos\nAliases: [\"/old-b\"]\n---\n# B\n"
+	if err := ioutil.WriteFile(filepath.Join(docroot, "b.md"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	host.Lock()
+	host.aliasesStale = true
+	host.Unlock()
+	if w := get(host, "/old-b"); w.Code == http.StatusMovedPermanently {
+		t.Error("content was scanned again right after the previous scan")
+	}
+
+	host.Lock()
+	host.aliasesScanned = time.Now().Add(-aliasScanInterval)
+	host.Unlock()
+	if w := get(host, "/old-b"); w.Code != http.StatusMovedPermanently {
+		t.Errorf("GET /old-b = %d after the scan interval, want 301", w.Code)
+	}
+}
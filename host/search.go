@@ -2,7 +2,9 @@ package host
 
 import (
 	"log"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/bradleypeabody/fulltext"
 )
@@ -16,6 +18,12 @@ func (host *Host) Search(terms []string, res int) []fulltext.SearchResultItem {
 		return empty
 	}
 
+	searchQueries.Inc(host.Name)
+	start := time.Now()
+	defer func() {
+		searchSeconds.Observe(time.Since(start).Seconds(), host.Name)
+	}()
+
 	ifile, ierr := host.GetIndexPath()
 	if ierr != nil {
 		log.Printf("getting index path for host %s: %v", host.Name, ierr)
@@ -36,3 +44,21 @@ func (host *Host) Search(terms []string, res int) []fulltext.SearchResultItem {
 	}
 	return sr.Items
 }
+
+// Search returns the results of a fulltext search on terms, leaving out the
+// pages the user of the request can't see.
+func (ctx *Context) Search(terms []string, res int) []fulltext.SearchResultItem {
+	items := ctx.Host.Search(terms, res)
+
+	root, err := ctx.GetContentPath()
+	if err != nil {
+		return items
+	}
+	visible := items[:0]
+	for _, item := range items {
+		if ctx.CanSee(path.Join(root, string(item.StoreValue))) {
+			visible = append(visible, item)
+		}
+	}
+	return visible
+}
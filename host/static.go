@@ -0,0 +1,98 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Encodings of precompressed siblings, in order of preference.
+var precompressed = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// AcceptsEncoding returns true if the request accepts a content encoding,
+// according to its Accept-Encoding header.
+func AcceptsEncoding(req *http.Request, encoding string) bool {
+	wildcard := false
+	for _, header := range req.Header["Accept-Encoding"] {
+		for _, item := range strings.Split(header, ",") {
+			parts := strings.Split(strings.TrimSpace(item), ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			q := 1.0
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = v
+					}
+				}
+			}
+			switch name {
+			case encoding:
+				return q > 0
+			case "*":
+				wildcard = q > 0
+			}
+		}
+	}
+	return wildcard
+}
+
+// serveFile serves a static file. When the client accepts it and a
+// precompressed sibling like "style.css.br" or "style.css.gz" exists, the
+// sibling is served instead with the matching Content-Encoding.
+func (ctx *Context) serveFile(file string, stat os.FileInfo) {
+	w, req := ctx.Response, ctx.Request
+
+	if req.Header.Get("Range") == "" {
+		for _, p := range precompressed {
+			if !AcceptsEncoding(req, p.encoding) {
+				continue
+			}
+			f, err := os.Open(file + p.ext)
+			if err != nil {
+				continue
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil || info.IsDir() || info.ModTime().Before(stat.ModTime()) {
+				// Ignore siblings that are older than the file itself.
+				continue
+			}
+			w.Header().Set("Content-Encoding", p.encoding)
+			w.Header().Add("Vary", "Accept-Encoding")
+			http.ServeContent(w, req, path.Base(file), stat.ModTime(), f)
+			return
+		}
+	}
+
+	http.ServeFile(w, req, file)
+}
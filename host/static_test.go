@@ -0,0 +1,123 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"br, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"deflate", false},
+		{"*", true},
+		{"*;q=0", false},
+		// An explicit entry overrides the wildcard.
+		{"*, gzip;q=0", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			req.Header.Set("Accept-Encoding", test.header)
+		}
+		if got := AcceptsEncoding(req, "gzip"); got != test.want {
+			t.Errorf("AcceptsEncoding(%q, gzip) = %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestPrecompressedFiles(t *testing.T) {
+	host := newTestHost(t, "localhost", map[string]string{
+		"content/style.css":    "body { color: red; }\n",
+		"content/style.css.gz": "gzipped style",
+		"content/style.css.br": "brotli style",
+		"content/app.js":       "alert(1);\n",
+		"content/app.js.gz":    "stale script",
+	})
+	content, _ := host.GetContentPath()
+	// A sibling older than its file is ignored.
+	now, old := time.Now(), time.Now().Add(-time.Hour)
+	for file, mtime := range map[string]time.Time{
+		"style.css":    old,
+		"style.css.gz": now,
+		"style.css.br": now,
+		"app.js.gz":    old,
+	} {
+		if err := os.Chtimes(filepath.Join(content, file), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		target   string
+		accept   string
+		rng      string
+		body     string
+		encoding string
+	}{
+		{"/style.css", "", "", "body { color: red; }\n", ""},
+		{"/style.css", "gzip", "", "gzipped style", "gzip"},
+		{"/style.css", "gzip, br", "", "brotli style", "br"},
+		{"/style.css", "br;q=0, gzip", "", "gzipped style", "gzip"},
+		// Byte ranges refer to the original file.
+		{"/style.css", "gzip", "bytes=0-3", "body", ""},
+		{"/app.js", "gzip", "", "alert(1);\n", ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept-Encoding", test.accept)
+		}
+		if test.rng != "" {
+			req.Header.Set("Range", test.rng)
+		}
+		w := httptest.NewRecorder()
+		host.ServeHTTP(w, req)
+
+		if got := w.Body.String(); got != test.body {
+			t.Errorf("%s (%q): body = %q, want %q", test.target, test.accept, got, test.body)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s (%q): Content-Encoding = %q, want %q", test.target, test.accept, got, test.encoding)
+		}
+		if test.encoding != "" {
+			if ct := w.Header().Get("Content-Type"); ct != "text/css; charset=utf-8" {
+				t.Errorf("%s (%q): Content-Type = %q, want the original file's", test.target, test.accept, ct)
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("%s (%q): missing Vary header", test.target, test.accept)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Status describes the state of a host.
+type Status struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	DocumentRoot string    `json:"document_root"`
+	Templates    []string  `json:"templates"`
+	IndexPath    string    `json:"index_path,omitempty"`
+	IndexBuilt   time.Time `json:"index_built"`
+	LastReload   time.Time `json:"last_reload"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// recordReload remembers the time and outcome of a reload.
+func (host *Host) recordReload(err error) {
+	host.Lock()
+	host.reloaded = time.Now()
+	host.reloadErr = err
+	host.Unlock()
+}
+
+// Reload reads site.yaml and the templates again.
+func (host *Host) Reload() error {
+	Reloads.Inc(host.Name, "site")
+	err := host.loadSettings()
+	if err == nil {
+		err = host.loadTemplates()
+	}
+	host.recordReload(err)
+	return err
+}
+
+// Status returns the current state of the host.
+func (host *Host) Status() Status {
+	s := Status{
+		Name:         host.Name,
+		Path:         host.Path,
+		DocumentRoot: host.DocumentRoot,
+		Templates:    []string{},
+	}
+
+	host.RLock()
+	for _, tpl := range host.TemplateGroup.Templates() {
+		if tpl.Name() != host.Name {
+			s.Templates = append(s.Templates, tpl.Name())
+		}
+	}
+	s.LastReload = host.reloaded
+	if host.reloadErr != nil {
+		s.LastError = host.reloadErr.Error()
+	}
+	host.RUnlock()
+	sort.Strings(s.Templates)
+
+	if ifile, err := host.GetIndexPath(); err == nil {
+		s.IndexPath = ifile
+		if info, err := os.Stat(ifile); err == nil {
+			s.IndexBuilt = info.ModTime()
+		}
+	}
+
+	return s
+}
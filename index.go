@@ -24,6 +24,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -32,6 +33,7 @@ import (
 	"strings"
 
 	"github.com/bradleypeabody/fulltext"
+	"github.com/lnxjedi/luminos/host"
 )
 
 // TODO: read extensions for indexing from site config
@@ -43,16 +45,39 @@ var fileExtensions = map[string]bool{
 
 var camelCaseRegex = regexp.MustCompile("([a-z][a-z])([A-Z][a-z])")
 
-func index(idxfile, content string) error {
+// indexHost builds the search index for a host, writing its progress to out.
+func indexHost(name string, h *host.Host, out io.Writer) error {
+	content, err := h.GetContentPath()
+	if err != nil {
+		return fmt.Errorf("error locating content path for '%s': %v", name, err)
+	}
+	ifile, err := h.GetIndexPath()
+	if err != nil {
+		return fmt.Errorf("error getting index path for '%s': %v", name, err)
+	}
+	fmt.Fprintf(out, "Creating '%s' for host '%s' with content path '%s'\n", ifile, name, content)
+	if err = index(ifile, content, out); err != nil {
+		return fmt.Errorf("error indexing '%s': %v", name, err)
+	}
+	return nil
+}
+
+// index writes the search index for the content directory, and the files
+// indexed to out. The index is written to a temporary file first so searches
+// never see a partial index.
+func index(idxfile, content string, out io.Writer) error {
 	var f *os.File
 	var err error
 
 	content = path.Clean(content)
 
-	f, err = os.Create(idxfile)
+	tmpfile := idxfile + ".tmp"
+	f, err = os.Create(tmpfile)
 	if err != nil {
 		return fmt.Errorf("creating index file: %v", err)
 	}
+	defer os.Remove(tmpfile)
+	defer f.Close()
 	// create new index with temp dir (usually "" is fine)
 	idx, err := fulltext.NewIndexer("")
 	if err != nil {
@@ -77,9 +102,9 @@ func index(idxfile, content string) error {
 		if fileExtensions[path.Ext(cpath)] {
 			c, err := ioutil.ReadFile(fpath)
 			if err != nil {
-				fmt.Printf("Error reading '%s' for indexing: %v\n", cpath, err)
+				fmt.Fprintf(out, "Error reading '%s' for indexing: %v\n", cpath, err)
 			} else {
-				fmt.Printf("Indexing %s: %s\n", pagetitle, cpath)
+				fmt.Fprintf(out, "Indexing %s: %s\n", pagetitle, cpath)
 			}
 			ic := bytes.NewBuffer(c)
 			// Make sure page title gets indexed
@@ -104,5 +129,11 @@ func index(idxfile, content string) error {
 	if err != nil {
 		return fmt.Errorf("finalizing index: %v", err)
 	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("finalizing index: %v", err)
+	}
+	if err = os.Rename(tmpfile, idxfile); err != nil {
+		return fmt.Errorf("replacing index: %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,275 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lnxjedi/to"
+)
+
+// Prefix for listener addresses that name a unix socket.
+const unixPrefix = "unix:"
+
+// listenerSettings returns the entries of the "listeners" list. When the
+// list is missing, a single listener is derived from the older server.type,
+// server.socket, server.bind and server.port settings.
+func listenerSettings() ([]map[string]interface{}, error) {
+	if list, ok := settings.Get("listeners").([]interface{}); ok {
+		entries := make([]map[string]interface{}, 0, len(list))
+		for i, item := range list {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("listener %d is not a map", i+1)
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	}
+
+	entry := map[string]interface{}{}
+
+	if socket := to.String(settings.Get("server", "socket")); socket != "" {
+		entry["address"] = unixPrefix + socket
+	} else {
+		entry["address"] = fmt.Sprintf("%s:%d", to.String(settings.Get("server", "bind")), to.Int64(settings.Get("server", "port")))
+	}
+
+	switch serverType := to.String(settings.Get("server", "type")); serverType {
+	case "fastcgi":
+		entry["protocol"] = "fastcgi"
+	case "standalone":
+		entry["protocol"] = "http"
+		if tlsSettings, ok := settings.Get("server", "tls").(map[string]interface{}); ok {
+			if _, ok := tlsSettings["certificates"]; ok {
+				entry["protocol"] = "https"
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unknown server type: %s", serverType)
+	}
+
+	return []map[string]interface{}{entry}, nil
+}
+
+// openListener creates a network listener for address. Addresses starting
+// with "unix:" are unix sockets, which get the file mode and owner from
+// conf when set.
+func openListener(address string, conf map[string]interface{}) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen(envServerProtocol, address)
+	}
+
+	socket := strings.TrimPrefix(address, unixPrefix)
+
+	// Remove a socket left behind by a previous run.
+	if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+
+	listener, err := net.Listen(envServerDomain, socket)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode := to.String(conf["mode"]); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err == nil {
+			err = os.Chmod(socket, os.FileMode(m))
+		}
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("setting mode of %s: %v", socket, err)
+		}
+	}
+
+	if owner := to.String(conf["owner"]); owner != "" {
+		if err := chownSocket(socket, owner); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("setting owner of %s: %v", socket, err)
+		}
+	}
+
+	return listener, nil
+}
+
+// chownSocket changes the owner of a socket given as "user" or
+// "user:group".
+func chownSocket(socket, owner string) error {
+	uid, gid := -1, -1
+
+	parts := strings.SplitN(owner, ":", 2)
+	if parts[0] != "" {
+		u, err := user.Lookup(parts[0])
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return err
+		}
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+
+	return os.Chown(socket, uid, gid)
+}
+
+// setServerLimits sets the timeouts and header size limit of a server from
+// the server section of settings.yaml, overridden by the listener's conf.
+func setServerLimits(srv *http.Server, conf map[string]interface{}) error {
+	srv.ReadHeaderTimeout = envReadHeaderTimeout
+	srv.IdleTimeout = envIdleTimeout
+
+	timeouts := map[string]*time.Duration{
+		"read_timeout":        &srv.ReadTimeout,
+		"read_header_timeout": &srv.ReadHeaderTimeout,
+		"write_timeout":       &srv.WriteTimeout,
+		"idle_timeout":        &srv.IdleTimeout,
+	}
+
+	for _, source := range []func(string) interface{}{
+		func(key string) interface{} { return settings.Get("server", key) },
+		func(key string) interface{} { return conf[key] },
+	} {
+		for key, field := range timeouts {
+			if v := to.String(source(key)); v != "" {
+				d, err := time.ParseDuration(v)
+				if err != nil {
+					return fmt.Errorf("%s: %v", key, err)
+				}
+				*field = d
+			}
+		}
+		if v := source("max_header_bytes"); v != nil {
+			srv.MaxHeaderBytes = int(to.Int64(v))
+		}
+	}
+	return nil
+}
+
+// newServer creates the server for one listener entry without opening the
+// listener. It also returns the TLS settings of "https" listeners.
+func newServer(conf map[string]interface{}) (*http.Server, map[string]interface{}, error) {
+	address := to.String(conf["address"])
+	if address == "" {
+		return nil, nil, errors.New("listener has no address")
+	}
+
+	handler := &server{admin: to.Bool(conf["admin"])}
+
+	// Optional list of host names this listener is restricted to.
+	if list, ok := conf["hosts"].([]interface{}); ok {
+		handler.hosts = map[string]bool{}
+		for _, name := range list {
+			handler.hosts[strings.TrimRight(to.String(name), "/")] = true
+		}
+	}
+
+	protocol := to.String(conf["protocol"])
+	switch protocol {
+	case "http", "https", "fastcgi":
+	default:
+		return nil, nil, fmt.Errorf("Unknown protocol for %s: %s", address, protocol)
+	}
+
+	var tlsSettings map[string]interface{}
+	if protocol == "https" {
+		// Listeners without their own "tls" section use server.tls.
+		var ok bool
+		if tlsSettings, ok = conf["tls"].(map[string]interface{}); !ok {
+			tlsSettings, _ = settings.Get("server", "tls").(map[string]interface{})
+		}
+	}
+
+	srv := &http.Server{Handler: handler}
+	srv.RegisterOnShutdown(stopLiveReload)
+	if err := setServerLimits(srv, conf); err != nil {
+		return nil, nil, fmt.Errorf("Invalid limits for %s: %v", address, err)
+	}
+	if protocol == "https" {
+		var err error
+		if srv.TLSConfig, err = loadTLSConfig(tlsSettings); err != nil {
+			return nil, nil, fmt.Errorf("Failed to configure TLS for %s: %q", address, err)
+		}
+		if srv.TLSConfig == nil {
+			return nil, nil, fmt.Errorf("HTTPS listener %s has no certificates", address)
+		}
+	}
+
+	return srv, tlsSettings, nil
+}
+
+// newServices creates the services for one listener entry: the listener
+// itself and, for HTTPS with a "redirect" address, a plain HTTP listener
+// that redirects to it.
+func newServices(conf map[string]interface{}) ([]service, error) {
+	srv, tlsSettings, err := newServer(conf)
+	if err != nil {
+		return nil, err
+	}
+	address := to.String(conf["address"])
+
+	listener, err := openListener(address, conf)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create network listener: %q", err)
+	}
+
+	if to.String(conf["protocol"]) == "fastcgi" {
+		return []service{&fcgiService{listener: listener, handler: srv.Handler}}, nil
+	}
+
+	services := []service{&httpService{listener: listener, server: srv, https: srv.TLSConfig != nil}}
+
+	// Optional plain HTTP listener that redirects to HTTPS.
+	if redirect := to.String(tlsSettings["redirect"]); redirect != "" {
+		rh, err := newHTTPSRedirect(address)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("Failed to start HTTPS redirect: %q", err)
+		}
+		rl, err := net.Listen(envServerProtocol, redirect)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("Could not create network listener: %q", err)
+		}
+		rs := &http.Server{Handler: rh}
+		setServerLimits(rs, conf)
+		services = append(services, &httpService{listener: rl, server: rs})
+	}
+
+	return services, nil
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/lnxjedi/dig"
+)
+
+// setTestSettings parses content as settings.yaml, without loading any
+// host, until the test ends.
+func setTestSettings(t *testing.T, content string) {
+	t.Helper()
+	var y dig.InterfaceMap
+	if err := yaml.Unmarshal([]byte(content), &y); err != nil {
+		t.Fatal(err)
+	}
+	saved := settings
+	settings = y
+	t.Cleanup(func() { settings = saved })
+}
+
+// startServices starts the services of a listener entry until the test ends.
+func startServices(t *testing.T, conf map[string]interface{}) []service {
+	t.Helper()
+	services, err := newServices(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range services {
+		go s.serve()
+		t.Cleanup(func() { s.shutdown(context.Background()) })
+	}
+	return services
+}
+
+// fcgiRecord encodes a FastCGI record of request 1.
+func fcgiRecord(kind byte, content []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{1, kind, 0, 1})
+	binary.Write(&b, binary.BigEndian, uint16(len(content)))
+	b.Write([]byte{0, 0})
+	b.Write(content)
+	return b.Bytes()
+}
+
+// fcgiGet sends a FastCGI GET request for uri on conn and returns what the
+// application wrote, headers included, once it ends the request.
+func fcgiGet(conn net.Conn, host, uri string) (string, error) {
+	var params bytes.Buffer
+	for _, kv := range [][2]string{
+		{"REQUEST_METHOD", "GET"},
+		{"REQUEST_URI", uri},
+		{"SERVER_PROTOCOL", "HTTP/1.1"},
+		{"HTTP_HOST", host},
+		{"REMOTE_ADDR", "192.0.2.1"},
+	} {
+		params.WriteByte(byte(len(kv[0])))
+		params.WriteByte(byte(len(kv[1])))
+		params.WriteString(kv[0] + kv[1])
+	}
+	// Begin a request with the responder role, then send its parameters
+	// and an empty body.
+	conn.Write(fcgiRecord(1, []byte{0, 1, 0, 0, 0, 0, 0, 0}))
+	conn.Write(fcgiRecord(4, params.Bytes()))
+	conn.Write(fcgiRecord(4, nil))
+	conn.Write(fcgiRecord(5, nil))
+
+	var out bytes.Buffer
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return out.String(), err
+		}
+		content := make([]byte, int(binary.BigEndian.Uint16(header[4:6]))+int(header[6]))
+		if _, err := io.ReadFull(conn, content); err != nil {
+			return out.String(), err
+		}
+		switch header[1] {
+		case 3: // End of the request.
+			return out.String(), nil
+		case 6: // Standard output.
+			out.Write(content[:binary.BigEndian.Uint16(header[4:6])])
+		}
+	}
+}
+
+func TestListenerSettings(t *testing.T) {
+	tests := []struct {
+		settings string
+		want     []string
+	}{
+		{"server:\n  type: standalone\n  bind: 127.0.0.1\n  port: 9000\n", []string{"http 127.0.0.1:9000"}},
+		{"server:\n  type: fastcgi\n  socket: /run/luminos.sock\n", []string{"fastcgi unix:/run/luminos.sock"}},
+		{"server:\n  type: standalone\n  port: 443\n  tls:\n    certificates: {}\n", []string{"https :443"}},
+		{"server:\n  type: standalone\n  port: 80\n  tls:\n    min_version: \"1.2\"\n", []string{"http :80"}},
+		{
+			"server:\n  type: fastcgi\nlisteners:\n  - { protocol: fastcgi, address: \"unix:/run/a.sock\" }\n  - { protocol: http, address: \"127.0.0.1:9001\" }\n",
+			[]string{"fastcgi unix:/run/a.sock", "http 127.0.0.1:9001"},
+		},
+	}
+	for _, test := range tests {
+		setTestSettings(t, test.settings)
+		entries, err := listenerSettings()
+		if err != nil {
+			t.Errorf("%q: %v", test.settings, err)
+			continue
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, fmt.Sprintf("%v %v", entry["protocol"], entry["address"]))
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%q: listeners %v, want %v", test.settings, got, test.want)
+		}
+	}
+
+	for _, invalid := range []string{
+		"server:\n  type: gopher\n",
+		"listeners:\n  - \"http://127.0.0.1\"\n",
+	} {
+		setTestSettings(t, invalid)
+		if _, err := listenerSettings(); err == nil {
+			t.Errorf("%q was accepted", invalid)
+		}
+	}
+
+	for _, conf := range []map[string]interface{}{
+		{"protocol": "http"},
+		{"protocol": "gopher", "address": "127.0.0.1:0"},
+		{"protocol": "https", "address": "127.0.0.1:0"},
+		{"protocol": "http", "address": "127.0.0.1:0", "read_timeout": "soon"},
+	} {
+		if _, _, err := newServer(conf); err == nil {
+			t.Errorf("listener %v was accepted", conf)
+		}
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	useHosts(t, newTestSite(t, defaultHost, map[string]string{"content/index.md": "# Home\n"}))
+	socket := filepath.Join(t.TempDir(), "luminos.sock")
+
+	// A socket left behind by a previous run is replaced.
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	startServices(t, map[string]interface{}{"protocol": "http", "address": unixPrefix + socket, "mode": "0600"})
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode is %v, want a socket with mode 0600", info.Mode())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Home") {
+		t.Errorf("GET over a unix socket = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestListenerHosts(t *testing.T) {
+	useHosts(t,
+		newTestSite(t, defaultHost, map[string]string{"content/index.md": "# Default\n"}),
+		newTestSite(t, "example.org", map[string]string{"content/index.md": "# Example\n"}),
+	)
+	services := startServices(t, map[string]interface{}{
+		"protocol": "http",
+		"address":  "127.0.0.1:0",
+		"hosts":    []interface{}{"example.org/"},
+	})
+	addr := services[0].(*httpService).listener.Addr().String()
+
+	tests := []struct {
+		host string
+		code int
+	}{
+		{"example.org", http.StatusOK},
+		{"localhost", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+		req.Host = test.host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("GET for %s = %d, want %d", test.host, resp.StatusCode, test.code)
+		}
+	}
+}
+
+func TestFastCGIListener(t *testing.T) {
+	useHosts(t, newTestSite(t, defaultHost, map[string]string{"content/index.md": "# Home\n"}))
+	services := startServices(t, map[string]interface{}{"protocol": "fastcgi", "address": "127.0.0.1:0"})
+	if _, ok := services[0].(*fcgiService); !ok {
+		t.Fatalf("service is %T, want FastCGI", services[0])
+	}
+
+	conn, err := net.Dial("tcp", services[0].(*fcgiService).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	out, err := fcgiGet(conn, "localhost", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Home") {
+		t.Errorf("FastCGI response %q doesn't have the page", out)
+	}
+}
+
+func TestFastCGIShutdownLiveReload(t *testing.T) {
+	h := newTestSite(t, defaultHost, map[string]string{"content/index.md": "# Home\n"})
+	h.LiveReload()
+	useHosts(t, h)
+	services := startServices(t, map[string]interface{}{"protocol": "fastcgi", "address": "127.0.0.1:0"})
+	s := services[0].(*fcgiService)
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	stream := make(chan string)
+	go func() {
+		out, _ := fcgiGet(conn, "localhost", "/_luminos/live-reload")
+		stream <- out
+	}()
+	for atomic.LoadInt64(&s.inflight) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The open stream doesn't hold up the shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.shutdown(ctx); err != nil {
+		h.StopLiveReload()
+		t.Fatalf("shutdown: %v", err)
+	}
+	if out := <-stream; !strings.Contains(out, "event: reload") {
+		t.Errorf("stream = %q, want a reload before it ends", out)
+	}
+}
@@ -22,6 +22,7 @@
 package main // package "github.com/lnxjedi/luminos"
 
 import (
+	"flag"
 	"log"
 	"os"
 
@@ -37,6 +38,13 @@ const Version = "v0.9.4-snapshot"
 // Commit of current build
 var Commit string
 
+// Commands that write data rather than messages to the standard output,
+// which would break with the banner in front, e.g. "luminos stylesheet >
+// syntax.css".
+var dataCommands = map[string]bool{
+	"stylesheet": true,
+}
+
 func main() {
 	// Software properties.
 	cli.Name = "Luminos Markdown Server"
@@ -49,11 +57,14 @@ func main() {
 	}
 
 	// Shows banner
-	cli.Banner()
+	flag.Parse()
+	if !dataCommands[flag.Arg(0)] {
+		cli.Banner()
+	}
 
 	// Dispatches the command.
 	if err := cli.Dispatch(); err != nil {
-		log.Fatal("Could not start Luminos: ", err)
+		log.Fatal("Luminos: ", err)
 	}
 
 }
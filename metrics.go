@@ -0,0 +1,80 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lnxjedi/luminos/host"
+	"github.com/lnxjedi/luminos/metrics"
+)
+
+// Default path of the metrics endpoint.
+const envMetricsPath = "/metrics"
+
+var (
+	requestsTotal = metrics.NewCounterVec("luminos_http_requests_total",
+		"HTTP requests by host and status code.", "host", "code")
+	requestSeconds = metrics.NewHistogramVec("luminos_http_request_duration_seconds",
+		"Time spent serving HTTP requests by host and status code.", nil, "host", "code")
+	_ = metrics.NewGaugeFunc("luminos_search_index_age_seconds",
+		"Seconds since the search index of each host was written.", indexAges, "host")
+)
+
+// Path of the metrics endpoint; empty when metrics are disabled.
+var metricsPath string
+
+// observeRequest records the metrics for a served request.
+func observeRequest(r *route, w *host.Response, start time.Time) {
+	name := ""
+	if r != nil {
+		name = r.host.Name
+	}
+	code := strconv.Itoa(w.Status)
+	requestsTotal.Inc(name, code)
+	requestSeconds.Observe(time.Since(start).Seconds(), name, code)
+}
+
+// indexAges returns the age of the search index of every host that has one.
+func indexAges() []metrics.Sample {
+	hostsLock.RLock()
+	defer hostsLock.RUnlock()
+
+	var samples []metrics.Sample
+	for name, h := range hosts {
+		ifile, err := h.GetIndexPath()
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(ifile)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, metrics.Sample{
+			Labels: []string{name},
+			Value:  time.Since(info.ModTime()).Seconds(),
+		})
+	}
+	return samples
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// output returns what a metric writes.
+func output(c collector) string {
+	var buf bytes.Buffer
+	c.write(&buf)
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	c := &CounterVec{
+		desc:   desc{name: "test_total", help: "Test\ncounter.", labels: []string{"host", "code"}},
+		values: map[string]float64{},
+		labels: map[string][]string{},
+	}
+	c.Inc("b.org", "200")
+	c.Inc("a.org", "404")
+	c.Add(2, "a.org", "404")
+	c.Inc(`say "hi"\`, "200")
+
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{host="a.org",code="404"} 3
+test_total{host="b.org",code="200"} 1
+test_total{host="say \"hi\"\\",code="200"} 1
+`
+	if got := output(c); got != want {
+		t.Errorf("counter output:\n%s\nwant:\n%s", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for a missing label value")
+		}
+	}()
+	c.Inc("a.org")
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := &HistogramVec{
+		desc:    desc{name: "test_seconds", help: "Test histogram.", labels: []string{"host"}},
+		buckets: []float64{0.1, 1},
+		values:  map[string]*histogram{},
+		labels:  map[string][]string{},
+	}
+	h.Observe(0.05, "a.org")
+	h.Observe(0.5, "a.org")
+	h.Observe(5, "a.org")
+
+	// Buckets are cumulative.
+	want := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{host="a.org",le="0.1"} 1
+test_seconds_bucket{host="a.org",le="1"} 2
+test_seconds_bucket{host="a.org",le="+Inf"} 3
+test_seconds_sum{host="a.org"} 5.55
+test_seconds_count{host="a.org"} 3
+`
+	if got := output(h); got != want {
+		t.Errorf("histogram output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeFuncAndHandler(t *testing.T) {
+	NewGaugeFunc("test_gauge_age_seconds", "Test gauge.", func() []Sample {
+		return []Sample{{Labels: []string{"b.org"}, Value: 2}, {Labels: []string{"a.org"}, Value: 1.5}}
+	}, "host")
+	NewCounterVec("test_handler_total", "Test counter without labels.").Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE test_gauge_age_seconds gauge\ntest_gauge_age_seconds{host=\"a.org\"} 1.5\ntest_gauge_age_seconds{host=\"b.org\"} 2\n",
+		"# TYPE test_handler_total counter\ntest_handler_total 1\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics don't have\n%s\nin:\n%s", line, body)
+		}
+	}
+}
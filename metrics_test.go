@@ -0,0 +1,131 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape returns the samples served at the metrics path, by series.
+func scrape(t *testing.T, s server, path string) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d", path, w.Code)
+	}
+	samples := map[string]float64{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("invalid sample %q", line)
+		}
+		samples[line[:i]] = v
+	}
+	return samples
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	site := writeTestSite(t, map[string]string{
+		"content/index.md":     "# Home\n",
+		"templates/search.tpl": "{{ $res := .Search .Query.terms 10 }}{{ len $res }}",
+	})
+	broken := writeTestSite(t, map[string]string{"templates/index.tpl": "{{ .Content "})
+	loadTestSettings(t, fmt.Sprintf("metrics:\n  path: /stats\nhosts:\n  default: %q\n  broken.example.org: %q\n", site, broken))
+
+	hostsLock.RLock()
+	h := hosts[defaultHost]
+	hostsLock.RUnlock()
+	if err := indexHost(defaultHost, h, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	before := scrape(t, server{}, "/stats")
+	for _, target := range []string{"/", "/", "/missing", "/search?terms=home"} {
+		serve(httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	after := scrape(t, server{}, "/stats")
+
+	tests := []struct {
+		series string
+		delta  float64
+	}{
+		{`luminos_http_requests_total{host="default",code="200"}`, 3},
+		{`luminos_http_requests_total{host="default",code="404"}`, 1},
+		{`luminos_http_request_duration_seconds_count{host="default",code="200"}`, 3},
+		// The second request for the home page is served from the cache.
+		{`luminos_markdown_render_seconds_count{host="default"}`, 1},
+		{`luminos_search_queries_total{host="default"}`, 1},
+		{`luminos_search_duration_seconds_count{host="default"}`, 1},
+	}
+	for _, test := range tests {
+		if d := after[test.series] - before[test.series]; d != test.delta {
+			t.Errorf("%s went up by %v, want %v", test.series, d, test.delta)
+		}
+	}
+	if after[`luminos_template_errors_total{host="broken.example.org"}`] < 1 {
+		t.Error("the broken template wasn't counted")
+	}
+	if _, ok := after[`luminos_search_index_age_seconds{host="default"}`]; !ok {
+		t.Error("the age of the search index is missing")
+	}
+	if after[`luminos_reloads_total{host="",kind="settings"}`] < 1 {
+		t.Error("loading settings.yaml wasn't counted")
+	}
+}
+
+func TestMetricsAdminListener(t *testing.T) {
+	site := writeTestSite(t, map[string]string{"content/index.md": "# Home\n"})
+	loadTestSettings(t, fmt.Sprintf("metrics: {}\nhosts:\n  default: %q\n", site))
+
+	// Without an admin listener every listener serves metrics.
+	scrape(t, server{}, "/metrics")
+
+	adminListener = true
+	defer func() { adminListener = false }()
+
+	w := httptest.NewRecorder()
+	server{}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(w.Body.String(), "luminos_http_requests_total") {
+		t.Error("metrics are served by a listener that isn't the admin listener")
+	}
+	scrape(t, server{admin: true}, "/metrics")
+
+	w = httptest.NewRecorder()
+	server{admin: true}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("admin listener served a page with %d, want 404", w.Code)
+	}
+}
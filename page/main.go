@@ -34,6 +34,8 @@ import (
 	"github.com/lnxjedi/dig"
 )
 
+// anchor is a link to a page. URLs are relative to the root of the site,
+// wherever it's mounted; templates turn them into links with asset.
 type anchor struct {
 	Text     string
 	URL      string
@@ -42,6 +44,8 @@ type anchor struct {
 
 type host interface {
 	Search([]string, int) []fulltext.SearchResultItem
+	// CanSee tells whether the user may read a content file or directory.
+	CanSee(file string) bool
 }
 
 var homeAnchor = anchor{Text: "Home", URL: "/"}
@@ -112,10 +116,30 @@ type Page struct {
 	// Relative parent directory of the current document.
 	BaseDir string
 
+	// Absolute URLs of the current document and of the site's root, as
+	// published by the site's baseURL or reverse proxy; for canonical links
+	// and feeds.
+	URL     string
+	BaseURL string
+
 	// True if the current document is / (home).
 	IsHome bool
 
-	// Pointer to the host for the search method
+	// HTTP status of error pages like 404.tpl; 0 for regular pages.
+	Status int
+
+	// Details of the error on error pages; only set in dev mode.
+	Error string
+
+	// User reading the page and their groups; empty for anonymous readers.
+	User   string
+	Groups []string
+
+	// Nonce for inline scripts and styles allowed by the site's
+	// Content-Security-Policy; empty when it doesn't use one.
+	Nonce string
+
+	// Per-request host context; provides the search method
 	Host host
 }
 
@@ -215,6 +239,17 @@ func fileFilter(f os.FileInfo) bool {
 	return false
 }
 
+// visible returns a filter that also leaves out the files in directory that
+// the user can't see.
+func (p *Page) visible(directory string, filter func(os.FileInfo) bool) func(os.FileInfo) bool {
+	return func(f os.FileInfo) bool {
+		if !filter(f) {
+			return false
+		}
+		return p.Host == nil || p.Host.CanSee(path.Join(directory, f.Name()))
+	}
+}
+
 // createTitle expects a filename and returns a stylized human title.
 func createTitle(s string) string {
 	s = removeKnownExtension(s)
@@ -244,11 +279,12 @@ func (p *Page) CreateMenu() {
 	var item anchor
 	p.Menu = []anchor{}
 
-	files := filterList(p.FileDir, directoryFilter)
+	files := filterList(p.FileDir, p.visible(p.FileDir, directoryFilter))
 
 	for _, file := range files {
 		item = p.CreateLink(file, p.BasePath)
-		children := filterList(p.FileDir+pathSeparator+file.Name(), directoryFilter)
+		dir := p.FileDir + pathSeparator + file.Name()
+		children := filterList(dir, p.visible(dir, directoryFilter))
 		if len(children) > 0 {
 			item.children = make([]anchor, 0, len(children))
 			for _, child := range children {
@@ -271,8 +307,14 @@ func (p *Page) CreateBreadCrumb() {
 
 	prefix := ""
 
+	// Local path of the content root.
+	root := strings.TrimSuffix(p.FileDir, strings.TrimLeft(p.BasePath, "/"))
+
 	for _, chunk := range chunks {
 		if chunk != "" {
+			if p.Host != nil && !p.Host.CanSee(root+strings.TrimLeft(prefix, "/")+pathSeparator+chunk) {
+				break
+			}
 
 			item := anchor{
 				URL:  prefix + "/" + chunk,
@@ -292,7 +334,7 @@ func (p *Page) CreateBreadCrumb() {
 func (p *Page) CreateSideMenu() {
 	var item anchor
 
-	files := filterList(p.FileDir, dummyFilter)
+	files := filterList(p.FileDir, p.visible(p.FileDir, dummyFilter))
 
 	p.SideMenu = make([]anchor, 0, len(files))
 
@@ -310,7 +352,8 @@ func (p *Page) CreateSideMenu() {
 	if len(p.SideMenu) == 0 {
 
 		// Attempt to index parent directory.
-		files = filterList(p.FileDir+pathSeparator+"..", dummyFilter)
+		parent := p.FileDir + pathSeparator + ".."
+		files = filterList(parent, p.visible(parent, dummyFilter))
 
 		for _, file := range files {
 			item = p.CreateLink(file, p.BasePath+".."+pathSeparator)
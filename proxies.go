@@ -0,0 +1,278 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/lnxjedi/to"
+)
+
+// proxyConfig holds the "proxies" section of settings.yaml.
+type proxyConfig struct {
+	// Networks of the proxies whose headers are trusted.
+	trusted []*net.IPNet
+	// Headers carrying the user and groups authenticated by the proxy; no
+	// identity is taken from proxies when userHeader is empty.
+	userHeader   string
+	groupsHeader string
+}
+
+// Trusted reverse proxies; nil when none are configured.
+var proxies *proxyConfig
+
+// loadProxies reads the "proxies" section of settings.yaml. It returns nil
+// when the section is missing.
+func loadProxies(conf map[string]interface{}) (*proxyConfig, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	p := &proxyConfig{
+		userHeader:   to.String(conf["user_header"]),
+		groupsHeader: to.String(conf["groups_header"]),
+	}
+
+	list, _ := conf["trusted"].([]interface{})
+	for _, item := range list {
+		cidr := to.String(item)
+		if !strings.Contains(cidr, "/") {
+			// A single address.
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %v", to.String(item), err)
+		}
+		p.trusted = append(p.trusted, network)
+	}
+	if len(p.trusted) == 0 {
+		return nil, fmt.Errorf("proxies requires a list of trusted networks")
+	}
+
+	return p, nil
+}
+
+// remoteIP returns the address of the peer of a request, without the port.
+func remoteIP(req *http.Request) string {
+	addr := req.RemoteAddr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		addr = h
+	}
+	return addr
+}
+
+// trustsIP returns true if an address belongs to a trusted proxy.
+func (p *proxyConfig) trustsIP(addr string) bool {
+	if p == nil {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusts returns true if a request comes directly from a trusted proxy.
+func (p *proxyConfig) trusts(req *http.Request) bool {
+	return p.trustsIP(remoteIP(req))
+}
+
+// forwardedRequest is what trusted proxies tell about the original request.
+type forwardedRequest struct {
+	// Address of the client; empty when the proxies didn't say.
+	client string
+	// Scheme and host name the client used, and the path prefix the proxy
+	// publishes the site under.
+	proto  string
+	host   string
+	prefix string
+}
+
+// forwardedHop is one element of a Forwarded header, or one address of
+// X-Forwarded-For.
+type forwardedHop struct {
+	addr   string
+	params map[string]string
+}
+
+// forwarded returns what the proxies in front of a request say about it,
+// either in an RFC 7239 Forwarded header or in the X-Forwarded-For, -Proto,
+// -Host and -Prefix headers. It's empty for requests that don't come
+// directly from a trusted proxy.
+//
+// The client is the last forwarding address that isn't a trusted proxy
+// itself, since anything before it may have been made up by the client.
+// The scheme and host come from the same Forwarded element, or from the
+// first value of the X-Forwarded headers, which trusted proxies are
+// expected to set rather than pass on.
+func (p *proxyConfig) forwarded(req *http.Request) forwardedRequest {
+	var f forwardedRequest
+	if !p.trusts(req) {
+		return f
+	}
+
+	hops := parseForwarded(req.Header["Forwarded"])
+	legacy := len(hops) == 0
+	if legacy {
+		for _, header := range req.Header["X-Forwarded-For"] {
+			for _, hop := range strings.Split(header, ",") {
+				if hop = strings.TrimSpace(hop); hop != "" {
+					hops = append(hops, forwardedHop{addr: hopAddress(hop)})
+				}
+			}
+		}
+	}
+
+	client := -1
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = i
+		if !p.trustsIP(hops[i].addr) {
+			break
+		}
+	}
+	if client >= 0 {
+		f.client = hops[client].addr
+	}
+
+	if legacy {
+		f.proto = firstValue(req.Header.Get("X-Forwarded-Proto"))
+		f.host = firstValue(req.Header.Get("X-Forwarded-Host"))
+	} else if client >= 0 {
+		// Proxies after the client's hop may fill in what it lacks.
+		for _, hop := range hops[client:] {
+			if f.proto == "" {
+				f.proto = hop.params["proto"]
+			}
+			if f.host == "" {
+				f.host = hop.params["host"]
+			}
+		}
+	}
+	f.prefix = firstValue(req.Header.Get("X-Forwarded-Prefix"))
+
+	// Ignore values that can't be right rather than pass them on.
+	if f.proto = strings.ToLower(f.proto); f.proto != "http" && f.proto != "https" {
+		f.proto = ""
+	}
+	if strings.ContainsAny(f.host, "/\\ @") {
+		f.host = ""
+	}
+	if f.prefix = strings.Trim(f.prefix, "/"); f.prefix != "" {
+		f.prefix = path.Clean("/" + f.prefix)
+	}
+	return f
+}
+
+// parseForwarded parses the elements of RFC 7239 Forwarded headers.
+func parseForwarded(headers []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			hop := forwardedHop{params: map[string]string{}}
+			for _, pair := range strings.Split(element, ";") {
+				i := strings.Index(pair, "=")
+				if i < 0 {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(pair[:i]))
+				value := strings.TrimSpace(pair[i+1:])
+				if unquoted, err := strconv.Unquote(value); err == nil {
+					value = unquoted
+				}
+				hop.params[key] = value
+			}
+			if len(hop.params) == 0 {
+				continue
+			}
+			hop.addr = hopAddress(hop.params["for"])
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// hopAddress returns a forwarding address without its port and brackets.
+func hopAddress(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// firstValue returns the first of a comma separated list of values.
+func firstValue(header string) string {
+	if i := strings.Index(header, ","); i > -1 {
+		header = header[:i]
+	}
+	return strings.TrimSpace(header)
+}
+
+// rewrite makes a request look like the one the client sent to the proxy,
+// so that routing and access logs see the client's address and host name.
+func (f forwardedRequest) rewrite(req *http.Request) {
+	if net.ParseIP(f.client) != nil {
+		req.RemoteAddr = f.client
+	}
+	if f.host != "" {
+		req.Host = f.host
+	}
+}
+
+// identity returns the user and groups set by a trusted proxy. The identity
+// headers of requests from anywhere else are removed.
+func (p *proxyConfig) identity(req *http.Request) (string, []string) {
+	if p == nil || p.userHeader == "" {
+		return "", nil
+	}
+	if !p.trusts(req) {
+		req.Header.Del(p.userHeader)
+		if p.groupsHeader != "" {
+			req.Header.Del(p.groupsHeader)
+		}
+		return "", nil
+	}
+
+	user := strings.TrimSpace(req.Header.Get(p.userHeader))
+	if user == "" || p.groupsHeader == "" {
+		return user, nil
+	}
+	groups := strings.FieldsFunc(req.Header.Get(p.groupsHeader), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	return user, groups
+}
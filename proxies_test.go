@@ -0,0 +1,286 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoadProxies(t *testing.T) {
+	if p, err := loadProxies(nil); p != nil || err != nil {
+		t.Errorf("loadProxies(nil) = %v, %v, want nil, nil", p, err)
+	}
+
+	p, err := loadProxies(map[string]interface{}{
+		"trusted": []interface{}{"127.0.0.1", "::1", "10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"127.0.0.1":   true,
+		"127.0.0.2":   false,
+		"::1":         true,
+		"10.1.2.3":    true,
+		"192.0.2.1":   false,
+		"not an addr": false,
+	} {
+		if got := p.trustsIP(addr); got != want {
+			t.Errorf("trustsIP(%q) = %v, want %v", addr, got, want)
+		}
+	}
+
+	for _, conf := range []map[string]interface{}{
+		{},
+		{"trusted": []interface{}{}},
+		{"trusted": []interface{}{"10.0.0.0/33"}},
+		{"trusted": []interface{}{"proxy.example.org"}},
+	} {
+		if _, err := loadProxies(conf); err == nil {
+			t.Errorf("loadProxies(%v) succeeded", conf)
+		}
+	}
+}
+
+func TestProxyIdentity(t *testing.T) {
+	p, err := loadProxies(map[string]interface{}{
+		"trusted":       []interface{}{"10.0.0.0/8"},
+		"user_header":   "X-Forwarded-User",
+		"groups_header": "X-Forwarded-Groups",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote string
+		user   string
+		groups string
+		want   string
+	}{
+		{"10.0.0.1:1234", "alice", "ops, dev", "alice [ops dev]"},
+		{"10.0.0.1:1234", "alice", "", "alice []"},
+		// Groups don't count without a user.
+		{"10.0.0.1:1234", "", "ops", " []"},
+		{"192.0.2.1:1234", "alice", "ops", " []"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remote
+		req.Header.Set("X-Forwarded-User", test.user)
+		req.Header.Set("X-Forwarded-Groups", test.groups)
+
+		user, groups := p.identity(req)
+		if got := fmt.Sprintf("%s %v", user, groups); got != test.want {
+			t.Errorf("%s %q %q: identity = %q, want %q", test.remote, test.user, test.groups, got, test.want)
+		}
+		// Anyone else's identity headers don't reach the hosts.
+		if !p.trusts(req) && (req.Header.Get("X-Forwarded-User") != "" || req.Header.Get("X-Forwarded-Groups") != "") {
+			t.Errorf("%s: identity headers were kept", test.remote)
+		}
+	}
+
+	// Without a user header, proxies don't tell identities.
+	var none *proxyConfig
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-User", "alice")
+	if user, _ := none.identity(req); user != "" {
+		t.Errorf("identity without proxies = %q", user)
+	}
+}
+
+func TestProxyIdentityAccess(t *testing.T) {
+	site := writeTestSite(t, map[string]string{
+		"content/index.md":     "# Home\n\nPublic words.\n",
+		"content/ops.md":       "---\n#luminos\nAccess: { Groups: [\"ops\"] }\n---\n# Ops\n\nSecret words.\n",
+		"templates/index.tpl":  "{{ .User }}|{{ range .SideMenu }}[{{ .Text }}]{{ end }}|{{ .Content }}",
+		"templates/search.tpl": "{{ range .Search .Query.terms 10 }}[{{ printf \"%s\" .StoreValue }}]{{ end }}",
+	})
+	loadTestSettings(t, fmt.Sprintf(`proxies:
+  trusted: ["10.0.0.1"]
+  user_header: "X-Forwarded-User"
+  groups_header: "X-Forwarded-Groups"
+hosts:
+  default: %q
+`, site))
+
+	hostsLock.RLock()
+	h := hosts[defaultHost]
+	hostsLock.RUnlock()
+	if err := indexHost(defaultHost, h, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(remote, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-User", "alice")
+		req.Header.Set("X-Forwarded-Groups", "ops")
+		return serve(req)
+	}
+
+	// From the proxy, alice is a member of ops.
+	if body := request("10.0.0.1:1234", "/").Body.String(); !strings.HasPrefix(body, "alice|") || !strings.Contains(body, "[ops]") {
+		t.Errorf("home page for alice = %q", body)
+	}
+	if w := request("10.0.0.1:1234", "/ops"); w.Code != http.StatusOK {
+		t.Errorf("/ops for alice = %d, want 200", w.Code)
+	}
+	if body := request("10.0.0.1:1234", "/search?terms=words").Body.String(); !strings.Contains(body, "ops.md") {
+		t.Errorf("search for alice = %q, want the ops page", body)
+	}
+
+	// Anyone else claiming to be alice is anonymous.
+	if body := request("192.0.2.1:1234", "/").Body.String(); !strings.HasPrefix(body, "|") || strings.Contains(body, "[ops]") {
+		t.Errorf("home page for others = %q", body)
+	}
+	if w := request("192.0.2.1:1234", "/ops"); w.Code == http.StatusOK || strings.Contains(w.Body.String(), "Secret") {
+		t.Errorf("/ops for others = %d %q, want it protected", w.Code, w.Body.String())
+	}
+	body := request("192.0.2.1:1234", "/search?terms=words").Body.String()
+	if strings.Contains(body, "ops.md") || !strings.Contains(body, "index.md") {
+		t.Errorf("search for others = %q, want only the home page", body)
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	p, err := loadProxies(map[string]interface{}{
+		"trusted": []interface{}{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		header map[string]string
+		want   forwardedRequest
+	}{
+		{
+			name:   "untrusted peer",
+			remote: "192.0.2.1:1234",
+			header: map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https"},
+		},
+		{
+			name:   "legacy headers",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{
+				"X-Forwarded-For":    "198.51.100.7",
+				"X-Forwarded-Proto":  "HTTPS",
+				"X-Forwarded-Host":   "docs.example.org, proxy.local",
+				"X-Forwarded-Prefix": "/manual/",
+			},
+			want: forwardedRequest{client: "198.51.100.7", proto: "https", host: "docs.example.org", prefix: "/manual"},
+		},
+		{
+			// Addresses before the client's own were made up by the client.
+			name:   "spoofed chain",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7, 10.0.0.2"},
+			want:   forwardedRequest{client: "198.51.100.7"},
+		},
+		{
+			name:   "forwarded header",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"Forwarded": `for=203.0.113.9;proto=http, for="[2001:db8::1]:4711";proto=https;host=docs.example.org, for=10.0.0.2`},
+			want:   forwardedRequest{client: "2001:db8::1", proto: "https", host: "docs.example.org"},
+		},
+		{
+			name:   "forwarded header over legacy ones",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"},
+			want:   forwardedRequest{client: "198.51.100.7"},
+		},
+		{
+			name:   "invalid values",
+			remote: "10.0.0.1:1234",
+			header: map[string]string{
+				"X-Forwarded-Proto":  "gopher",
+				"X-Forwarded-Host":   "evil.example.org/path",
+				"X-Forwarded-Prefix": "a/../../b",
+			},
+			want: forwardedRequest{prefix: "/b"},
+		},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remote
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+		if got := p.forwarded(req); got != test.want {
+			t.Errorf("%s: forwarded = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestForwardedRequests(t *testing.T) {
+	site := writeTestSite(t, map[string]string{
+		"content/index.md":    "# Home\n",
+		"templates/index.tpl": "{{ .URL }} {{ .BaseURL }} {{ url \"/about\" }}",
+	})
+	based := writeTestSite(t, map[string]string{
+		"site.yaml":           "title: Test\nbaseURL: \"https://www.example.com/manual/\"\n",
+		"content/index.md":    "# Home\n",
+		"templates/index.tpl": "{{ .URL }} {{ .BaseURL }} {{ url \"/about\" }}",
+	})
+	loadTestSettings(t, fmt.Sprintf(`proxies:
+  trusted: ["10.0.0.1"]
+hosts:
+  default: %q
+  docs.example.org/handbook: %q
+  based.example.org: %q
+`, site, site, based))
+
+	tests := []struct {
+		remote string
+		host   string
+		target string
+		header map[string]string
+		want   string
+	}{
+		{"192.0.2.1:1234", "localhost", "/", nil, "http://localhost/ http://localhost/ http://localhost/about"},
+		// Only trusted proxies can change the scheme, host and prefix.
+		{"192.0.2.1:1234", "localhost", "/", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "docs.example.org"}, "http://localhost/ http://localhost/ http://localhost/about"},
+		{"10.0.0.1:1234", "localhost", "/handbook/", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "docs.example.org", "X-Forwarded-Prefix": "/site"},
+			"https://docs.example.org/site/handbook/ https://docs.example.org/site/handbook/ https://docs.example.org/site/handbook/about"},
+		{"10.0.0.1:1234", "localhost", "/", map[string]string{"Forwarded": "for=198.51.100.7;proto=https;host=based.example.org"},
+			"https://www.example.com/manual/ https://www.example.com/manual/ https://www.example.com/manual/about"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		req.Host, req.RemoteAddr = test.host, test.remote
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+		w := serve(req)
+		if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || got != test.want {
+			t.Errorf("%s %v: got %d %q, want %q", test.target, test.header, w.Code, got, test.want)
+		}
+	}
+}
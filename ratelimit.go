@@ -0,0 +1,192 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/luminos/metrics"
+	"github.com/lnxjedi/to"
+)
+
+// How often buckets of idle clients are dropped.
+const limiterSweepInterval = time.Minute
+
+var rateLimited = metrics.NewCounterVec("luminos_rate_limited_total",
+	"Requests rejected by rate limits by host and budget.", "host", "budget")
+
+// bucket holds the tokens of one client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a token bucket rate limiter per client address.
+type limiter struct {
+	// Tokens added per second, and the size of the bucket.
+	rate  float64
+	burst float64
+
+	sync.Mutex
+	clients map[string]*bucket
+	swept   time.Time
+}
+
+// newLimiter reads a rate limit budget with "rate" requests per second and
+// an optional "burst". It returns nil when conf is missing.
+func newLimiter(name string, conf interface{}) (*limiter, error) {
+	m, ok := conf.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	l := &limiter{rate: to.Float64(m["rate"]), clients: map[string]*bucket{}}
+	if l.rate <= 0 {
+		return nil, fmt.Errorf("rate_limit %s requires a positive rate", name)
+	}
+	if l.burst = to.Float64(m["burst"]); l.burst < 1 {
+		l.burst = math.Max(1, l.rate)
+	}
+	return l, nil
+}
+
+// allow takes a token for a client. When there's none left it returns false
+// and the time until the next one.
+func (l *limiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.swept) > limiterSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// equal returns true if two budgets, either of which may be nil, have the
+// same rate and burst.
+func (l *limiter) equal(other *limiter) bool {
+	return l != nil && other != nil && l.rate == other.rate && l.burst == other.burst
+}
+
+// sweep drops the buckets that have filled up again.
+func (l *limiter) sweep(now time.Time) {
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.clients, client)
+		}
+	}
+	l.swept = now
+}
+
+// rateLimits holds the "rate_limit" section of settings.yaml; either budget
+// may be nil.
+type rateLimits struct {
+	pages  *limiter
+	search *limiter
+}
+
+// Rate limits; nil when disabled.
+var limits *rateLimits
+
+// loadRateLimits reads the "rate_limit" section of settings.yaml.
+func loadRateLimits(conf map[string]interface{}) (*rateLimits, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	var l rateLimits
+	var err error
+	if l.pages, err = newLimiter("pages", conf["pages"]); err != nil {
+		return nil, err
+	}
+	if l.search, err = newLimiter("search", conf["search"]); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// reuse takes the budgets of old whose rate and burst didn't change, so that
+// reloading settings doesn't give every client a full bucket again.
+func (l *rateLimits) reuse(old *rateLimits) {
+	if l == nil || old == nil {
+		return
+	}
+	if old.pages.equal(l.pages) {
+		l.pages = old.pages
+	}
+	if old.search.equal(l.search) {
+		l.search = old.search
+	}
+}
+
+// limit applies the search or pages budget to a request for a host. It sends
+// a 429 and returns false when the client is over budget. Clients without an
+// IP address, such as those of unix socket listeners, aren't limited, since
+// they would all share one bucket.
+func (l *rateLimits) limit(w http.ResponseWriter, r *route, req *http.Request, client string) bool {
+	if l == nil || net.ParseIP(client) == nil {
+		return true
+	}
+
+	budget, lim := "pages", l.pages
+	if isSearch(r, req) {
+		budget, lim = "search", l.search
+	}
+	if lim == nil {
+		return true
+	}
+
+	ok, wait := lim.allow(client, time.Now())
+	if ok {
+		return true
+	}
+	rateLimited.Inc(r.host.Name, budget)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return false
+}
+
+// isSearch returns true for requests of a host's search page.
+func isSearch(r *route, req *http.Request) bool {
+	p := req.URL.Path
+	if len(p) >= len(r.path) {
+		p = p[len(r.path):]
+	}
+	return p == "/search" || p == "/search/"
+}
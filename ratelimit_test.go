@@ -0,0 +1,127 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitsReuse(t *testing.T) {
+	load := func(pages, search interface{}) *rateLimits {
+		t.Helper()
+		l, err := loadRateLimits(map[string]interface{}{"pages": pages, "search": search})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	pages := map[string]interface{}{"rate": 1, "burst": 1}
+	search := map[string]interface{}{"rate": 1, "burst": 1}
+
+	old := load(pages, search)
+	now := time.Now()
+	old.pages.allow("192.0.2.1", now)
+	old.search.allow("192.0.2.1", now)
+
+	// The pages budget is unchanged and keeps its buckets; the search budget
+	// has a new burst and starts over.
+	l := load(pages, map[string]interface{}{"rate": 1, "burst": 2})
+	l.reuse(old)
+	if l.pages != old.pages {
+		t.Error("unchanged pages budget was replaced")
+	}
+	if ok, _ := l.pages.allow("192.0.2.1", now); ok {
+		t.Error("client got a full pages bucket after reload")
+	}
+	if l.search == old.search {
+		t.Error("changed search budget was kept")
+	}
+	if ok, _ := l.search.allow("192.0.2.1", now); !ok {
+		t.Error("client was limited by the new search budget")
+	}
+
+	// Budgets that are added or removed aren't taken from the old limits.
+	l = load(nil, search)
+	l.reuse(old)
+	if l.pages != nil || l.search != old.search {
+		t.Errorf("reuse = %+v, want only the old search budget", l)
+	}
+	var none *rateLimits
+	none.reuse(old)
+	load(pages, search).reuse(nil)
+}
+
+func TestRateLimitPagesOnly(t *testing.T) {
+	useHosts(t, newTestSite(t, defaultHost, map[string]string{
+		"content/index.md":  "# Home\n",
+		"content/about.md":  "# About\n",
+		"content/notes.txt": "Notes\n",
+		"webroot/css/a.css": "body {}\n",
+		"webroot/js/a.js":   "var a;\n",
+		"webroot/img/a.png": "PNG",
+		"webroot/css/b.css": "p {}\n",
+	}))
+	l, err := loadRateLimits(map[string]interface{}{
+		"pages": map[string]interface{}{"rate": 0.001, "burst": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostsLock.Lock()
+	saved := limits
+	limits = l
+	hostsLock.Unlock()
+	defer func() {
+		hostsLock.Lock()
+		limits = saved
+		hostsLock.Unlock()
+	}()
+
+	request := func(target, remote string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remote
+		return serve(req).Code
+	}
+
+	// A page and its assets take one token.
+	for _, target := range []string{"/", "/css/a.css", "/js/a.js", "/img/a.png", "/css/b.css", "/notes.txt"} {
+		if code := request(target, "192.0.2.1:1234"); code != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", target, code)
+		}
+	}
+	if code := request("/about", "192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("second page = %d, want 429", code)
+	}
+	if code := request("/about", "192.0.2.2:1234"); code != http.StatusOK {
+		t.Errorf("page for another client = %d, want 200", code)
+	}
+
+	// Clients of unix sockets have no address to tell them apart.
+	for i := 0; i < 3; i++ {
+		if code := request("/about", "@"); code != http.StatusOK {
+			t.Errorf("page %d over a unix socket = %d, want 200", i+1, code)
+		}
+	}
+}
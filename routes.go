@@ -0,0 +1,213 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lnxjedi/luminos/host"
+)
+
+// Name of the host used when no route matches a request.
+const defaultHost = "default"
+
+// Kinds of host name patterns, from least to most specific.
+const (
+	matchAnyName = iota
+	matchWildcard
+	matchExactName
+)
+
+// route maps a host name pattern and a path prefix to a host.
+type route struct {
+	// Key as written in settings.yaml, e.g. "example.org/docs".
+	key string
+	// Host name pattern: an exact name, a wildcard like "*.example.org" or
+	// empty to match any name.
+	name string
+	// Path prefix, without a trailing slash; empty for the root.
+	path string
+	// Host that serves the route.
+	host *host.Host
+	// When set, requests that don't use this host name are redirected to it.
+	canonical string
+}
+
+// routingTable is a list of routes sorted from most to least specific.
+type routingTable []*route
+
+// newRoute parses a key like "example.org/docs", "*.example.org" or "/docs"
+// into a route. Host names are case-insensitive but paths aren't, so only
+// the name is lowercased and the path stays the host's mount path.
+func newRoute(key string, h *host.Host) *route {
+	r := &route{key: key, host: h}
+	key = strings.TrimRight(key, "/")
+	if i := strings.Index(key, "/"); i > -1 {
+		r.name, r.path = key[:i], key[i:]
+	} else {
+		r.name = key
+	}
+	r.name = strings.ToLower(r.name)
+	return r
+}
+
+// kind returns how the route matches host names.
+func (r *route) kind() int {
+	switch {
+	case r.name == "":
+		return matchAnyName
+	case strings.HasPrefix(r.name, "*."):
+		return matchWildcard
+	}
+	return matchExactName
+}
+
+// matches returns true if the route applies to the given host name and path.
+func (r *route) matches(name, path string) bool {
+	switch r.kind() {
+	case matchWildcard:
+		suffix := r.name[1:]
+		if len(name) <= len(suffix) || !strings.HasSuffix(name, suffix) {
+			return false
+		}
+	case matchExactName:
+		if name != r.name {
+			return false
+		}
+	}
+	return r.path == "" || path == r.path || strings.HasPrefix(path, r.path+"/")
+}
+
+// redirect sends a request that didn't use the canonical host name to the
+// same page on the canonical host, or below the host's baseURL when it has
+// one. The scheme and path prefix are the ones the client used. It returns
+// false when no redirect was needed.
+func (r *route) redirect(w http.ResponseWriter, req *http.Request, name, scheme, prefix string) bool {
+	if r.canonical == "" || name == r.canonical {
+		return false
+	}
+	target := *req.URL
+	target.Scheme = scheme
+	target.Host = r.canonical
+	target.RawPath = ""
+	target.Path = prefix + r.host.Path + strings.TrimPrefix(req.URL.Path, r.path)
+	if base := r.host.BaseURL(); base != nil {
+		target.Scheme = base.Scheme
+		target.Host = base.Host
+		target.Path = strings.TrimRight(base.Path, "/") + strings.TrimPrefix(req.URL.Path, r.path)
+	}
+	if target.Path == "" {
+		target.Path = "/"
+	}
+	http.Redirect(w, req, target.String(), http.StatusMovedPermanently)
+	return true
+}
+
+// Len is part of sort.Interface.
+func (t routingTable) Len() int {
+	return len(t)
+}
+
+// Less sorts exact host names before wildcards and wildcards before
+// path-only routes; within each kind longer names and then longer paths
+// come first. Ties are broken by key so the order never depends on map
+// iteration.
+func (t routingTable) Less(i, j int) bool {
+	a, b := t[i], t[j]
+	if a.kind() != b.kind() {
+		return a.kind() > b.kind()
+	}
+	if len(a.name) != len(b.name) {
+		return len(a.name) > len(b.name)
+	}
+	if len(a.path) != len(b.path) {
+		return len(a.path) > len(b.path)
+	}
+	return a.key < b.key
+}
+
+// Swap is part of sort.Interface.
+func (t routingTable) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
+}
+
+// add appends a route, failing if the same route was already declared.
+func (t *routingTable) add(r *route) error {
+	for _, existing := range *t {
+		if existing.name == r.name && existing.path == r.path {
+			return fmt.Errorf("route %s for host %s is already used by host %s", r.key, r.host.Name, existing.host.Name)
+		}
+	}
+	*t = append(*t, r)
+	return nil
+}
+
+// lookup returns the most specific route for a host name and path, or nil.
+func (t routingTable) lookup(name, path string) *route {
+	name = strings.ToLower(name)
+	for _, r := range t {
+		if r.matches(name, path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// buildRoutes creates the routing table for hosts, adding a route for every
+// alias in aliases (keyed by host name).
+func buildRoutes(hosts map[string]*host.Host, aliases map[string][]string, canonical map[string]bool) (routingTable, error) {
+	var t routingTable
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := hosts[name]
+		r := newRoute(name, h)
+		// The default host is only used as a fallback.
+		if name != defaultHost {
+			if err := t.add(r); err != nil {
+				return nil, err
+			}
+		}
+		var redirect string
+		if canonical[name] {
+			if name == defaultHost || r.kind() != matchExactName {
+				return nil, fmt.Errorf("canonical host %s must be an exact host name", name)
+			}
+			redirect = r.name
+		}
+		for _, alias := range aliases[name] {
+			a := newRoute(alias, h)
+			a.canonical = redirect
+			if err := t.add(a); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Sort(t)
+	return t, nil
+}
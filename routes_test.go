@@ -0,0 +1,196 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lnxjedi/luminos/host"
+)
+
+// testHosts returns hosts for keys, without loading any site.
+func testHosts(keys ...string) map[string]*host.Host {
+	hosts := map[string]*host.Host{}
+	for _, key := range keys {
+		hosts[key] = &host.Host{Name: key}
+	}
+	return hosts
+}
+
+func TestNewRoute(t *testing.T) {
+	tests := []struct {
+		key, name, path string
+		kind            int
+	}{
+		{"example.org", "example.org", "", matchExactName},
+		{"Example.ORG/", "example.org", "", matchExactName},
+		{"example.org/docs", "example.org", "/docs", matchExactName},
+		{"Example.org/Docs/", "example.org", "/Docs", matchExactName},
+		{"*.example.org", "*.example.org", "", matchWildcard},
+		{"*.Example.org/API", "*.example.org", "/API", matchWildcard},
+		{"/docs", "", "/docs", matchAnyName},
+	}
+	for _, test := range tests {
+		r := newRoute(test.key, nil)
+		if r.name != test.name || r.path != test.path || r.kind() != test.kind {
+			t.Errorf("newRoute(%q) = name %q, path %q, kind %d; want %q, %q, %d",
+				test.key, r.name, r.path, r.kind(), test.name, test.path, test.kind)
+		}
+	}
+}
+
+func TestBuildRoutesOrder(t *testing.T) {
+	hosts := testHosts(
+		"default",
+		"/docs",
+		"/docs/api",
+		"*.example.org",
+		"*.dev.example.org",
+		"example.org",
+		"example.org/docs",
+		"b.example.org",
+		"a.example.org",
+	)
+	table, err := buildRoutes(hosts, map[string][]string{"example.org": {"www.example.org"}}, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exact names come first, longer names and then longer paths first,
+	// ties by key; then wildcards and path-only routes. The default host
+	// isn't routed.
+	want := []string{
+		"www.example.org",
+		"a.example.org",
+		"b.example.org",
+		"example.org/docs",
+		"example.org",
+		"*.dev.example.org",
+		"*.example.org",
+		"/docs/api",
+		"/docs",
+	}
+	if len(table) != len(want) {
+		t.Fatalf("got %d routes, want %d", len(table), len(want))
+	}
+	for i, r := range table {
+		if r.key != want[i] {
+			t.Errorf("route %d is %s, want %s", i, r.key, want[i])
+		}
+	}
+
+	// The order doesn't depend on the order routes were added in.
+	for i := 0; i < 10; i++ {
+		again, _ := buildRoutes(hosts, map[string][]string{"example.org": {"www.example.org"}}, map[string]bool{})
+		for j := range again {
+			if again[j].key != table[j].key {
+				t.Fatalf("route %d is %s on build %d, want %s", j, again[j].key, i, table[j].key)
+			}
+		}
+	}
+}
+
+func TestBuildRoutesErrors(t *testing.T) {
+	if _, err := buildRoutes(testHosts("example.org", "Example.org/"), nil, nil); err == nil {
+		t.Error("duplicate routes were accepted")
+	}
+	if _, err := buildRoutes(testHosts("a.org"), map[string][]string{"a.org": {"a.org"}}, nil); err == nil {
+		t.Error("an alias duplicating its host was accepted")
+	}
+	if _, err := buildRoutes(testHosts("*.a.org"), nil, map[string]bool{"*.a.org": true}); err == nil {
+		t.Error("a wildcard canonical host was accepted")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table, err := buildRoutes(testHosts(
+		"example.org",
+		"example.org/docs",
+		"example.org/Guide",
+		"*.example.org",
+		"/static",
+	), map[string][]string{"example.org": {"www.example.org"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, path, want string
+	}{
+		{"example.org", "/", "example.org"},
+		{"EXAMPLE.org", "/page", "example.org"},
+		{"example.org", "/docs", "example.org/docs"},
+		{"example.org", "/docs/intro", "example.org/docs"},
+		{"example.org", "/docsearch", "example.org"},
+		{"example.org", "/Guide/x", "example.org/Guide"},
+		{"example.org", "/guide/x", "example.org"},
+		{"www.example.org", "/docs", "www.example.org"},
+		{"a.example.org", "/docs", "*.example.org"},
+		{"a.b.example.org", "/", "*.example.org"},
+		{"other.org", "/static/app.js", "/static"},
+		{"example.org", "/static/app.js", "example.org"},
+		{"other.org", "/", ""},
+		{"badexample.org", "/", ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if r := table.lookup(test.name, test.path); r != nil {
+			got = r.key
+		}
+		if got != test.want {
+			t.Errorf("lookup(%q, %q) = %q, want %q", test.name, test.path, got, test.want)
+		}
+	}
+}
+
+func TestFindRouteMountPath(t *testing.T) {
+	docs := &host.Host{Name: "Example.org/Docs", Path: "/Docs"}
+	fallback := &host.Host{Name: defaultHost}
+	table, err := buildRoutes(map[string]*host.Host{docs.Name: docs, defaultHost: fallback}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostsLock.Lock()
+	savedHosts, savedRoutes := hosts, routes
+	hosts, routes = map[string]*host.Host{docs.Name: docs, defaultHost: fallback}, table
+	hostsLock.Unlock()
+	defer func() {
+		hostsLock.Lock()
+		hosts, routes = savedHosts, savedRoutes
+		hostsLock.Unlock()
+	}()
+
+	r, name := findRoute(httptest.NewRequest("GET", "http://EXAMPLE.org:8080/Docs/intro", nil))
+	if r == nil || r.host != docs || name != "example.org" {
+		t.Fatalf("findRoute = %v, %q; want the docs host for example.org", r, name)
+	}
+	// The mount path is stripped from requests as the host was configured.
+	if r.path != docs.Path {
+		t.Errorf("mount path is %q, want %q", r.path, docs.Path)
+	}
+
+	if r, _ := findRoute(httptest.NewRequest("GET", "http://example.org/docs/intro", nil)); r == nil || r.host != fallback {
+		t.Errorf("paths are case-sensitive, want the default host for /docs")
+	}
+}
@@ -22,101 +22,241 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/ghodss/yaml"
 	"github.com/lnxjedi/dig"
+	"github.com/lnxjedi/luminos/accesslog"
 	"github.com/lnxjedi/luminos/host"
+	"github.com/lnxjedi/luminos/metrics"
 	"github.com/lnxjedi/to"
 )
 
 // Map of hosts.
 var hosts map[string]*host.Host
 
+// Routes to hosts, most specific first.
+var routes routingTable
+
+// Global access log.
+var accessLog *accesslog.Logger
+
+// Lock for hosts, routes and the access log.
+var hostsLock sync.RWMutex
+
 // File watcher.
 var watch *fsnotify.Watcher
 
+// server routes requests to hosts.
 type server struct {
+	// Names of the hosts served; nil serves all hosts.
+	hosts map[string]bool
+	// True for admin listeners, which only serve admin endpoints.
+	admin bool
 }
 
+// True when some listener is an admin listener; admin endpoints are then
+// only served there.
+var adminListener bool
+
+// Dev mode from settings.yaml; shows error details to visitors.
+var devMode bool
+
 func init() {
 	// Allocating map.
 	hosts = make(map[string]*host.Host)
 }
 
-// Finds the appropriate hosts for a request.
-func route(req *http.Request) *host.Host {
+// Finds the appropriate route for a request.
+func findRoute(req *http.Request) (*route, string) {
 
 	// Request's hostname.
 	name := req.Host
 
 	// Removing the port part of the host.
-	if strings.Contains(name, ":") {
-		name = name[0:strings.Index(name, ":")]
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
 	}
+	name = strings.ToLower(name)
 
-	// Host and path.
-	path := name + req.URL.Path
-
-	// Searching for the host that best matches this request.
-	match := ""
+	hostsLock.RLock()
+	defer hostsLock.RUnlock()
 
-	for key := range hosts {
-		lkey := len(key)
-		if key[0] == '/' {
-			if lkey <= len(req.URL.Path) {
-				if req.URL.Path[0:lkey] == key {
-					match = key
-				}
-			}
-		}
-		if lkey <= len(path) {
-			if path[0:lkey] == key {
-				match = key
-			}
-		}
+	// Searching for the most specific route for this request.
+	if r := routes.lookup(name, req.URL.Path); r != nil {
+		return r, name
 	}
 
 	// No host matched, let's use the default host.
-	if match == "" {
-		// log.Printf("Path %v could not match any route, falling back to the default.\n", path)
-		match = "default"
+	if h, ok := hosts[defaultHost]; ok {
+		return newRoute(defaultHost, h), name
 	}
 
-	// Let's verify and return the host.
-	if _, ok := hosts[match]; !ok {
-		// Host was not found.
-		log.Printf("Request for unknown host: %s\n", req.Host)
-		return nil
-	}
-
-	return hosts[match]
+	// Host was not found.
+	log.Printf("Request for unknown host: %s\n", req.Host)
+	return nil, name
 }
 
 // Routes a request and lets the host handle it.
 func (s server) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
-	r := route(req)
-	if r != nil {
-		r.ServeHTTP(wri, req)
-	} else {
+	start := time.Now()
+	w := &host.Response{ResponseWriter: wri}
+
+	// Admin endpoints are answered before routing to hosts.
+	if s.serveAdmin(w, req) {
+		return
+	}
+
+	hostsLock.RLock()
+	c := compression
+	dev := devMode
+	px := proxies
+	l := limits
+	hostsLock.RUnlock()
+
+	// Requests from trusted proxies are routed and logged as the client
+	// sent them to the proxy.
+	user, groups := px.identity(req)
+	w.User = user
+	fw := px.forwarded(req)
+	fw.rewrite(req)
+
+	r, name := findRoute(req)
+	if r != nil && s.hosts != nil && !s.hosts[r.host.Name] {
+		r = nil
+	}
+	defer func() {
+		logAccess(r, req, w, start)
+		observeRequest(r, w, start)
+	}()
+
+	if r == nil {
 		log.Printf("Failed to serve host %s.\n", req.Host)
-		http.Error(wri, "Not found", http.StatusNotFound)
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	scheme := fw.proto
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+
+	if r.redirect(w, req, name, scheme, fw.prefix) {
+		return
+	}
+	cw, done := c.wrap(w, req)
+	defer done()
+
+	ctx := r.host.NewContext(cw, req)
+	ctx.MountPath = r.path
+	ctx.Scheme = scheme
+	ctx.Prefix = fw.prefix
+	ctx.Dev = dev
+	ctx.User, ctx.Groups = user, groups
+	ctx.Allow = func() bool { return l.limit(ctx.Response, r, req, remoteIP(req)) }
+	ctx.Serve()
+	// The host may have authenticated the user itself.
+	w.User = ctx.User
+}
+
+// serveAdmin answers requests for admin endpoints, returning false when the
+// request should be routed to a host.
+func (s server) serveAdmin(w http.ResponseWriter, req *http.Request) bool {
+	if !s.admin && adminListener {
+		return false
+	}
+
+	hostsLock.RLock()
+	mpath := metricsPath
+	a := admin
+	hostsLock.RUnlock()
+
+	if mpath != "" && req.URL.Path == mpath {
+		metrics.Handler().ServeHTTP(w, req)
+		return true
+	}
+
+	if a.path != "" && (req.URL.Path == a.path || strings.HasPrefix(req.URL.Path, a.path+"/")) {
+		serveAdminAPI(w, req, a, strings.TrimPrefix(req.URL.Path, a.path))
+		return true
+	}
+
+	if s.admin {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return true
+	}
+	return false
+}
+
+// logAccess writes the access log entry for a request to the log of the host
+// that served it or, failing that, to the global access log.
+func logAccess(r *route, req *http.Request, w *host.Response, start time.Time) {
+	e := accesslog.NewEntry(req, start, w.Status, w.Size)
+	e.User = w.User
+	if r != nil && r.host.LogAccess(e) {
+		return
+	}
+	hostsLock.RLock()
+	logger := accessLog
+	hostsLock.RUnlock()
+	logger.Log(e)
+}
+
+// hostEntry reads the entry of a host in settings.yaml and returns the
+// directory of the site, which must exist. An entry is either the path to
+// the site or a map with the path under "root" and optional "aliases" and
+// "canonical" settings, which are added to the given maps.
+func hostEntry(name string, entry interface{}, aliases map[string][]string, canonical map[string]bool) (string, error) {
+	var path string
+
+	switch entry := entry.(type) {
+	case map[string]interface{}:
+		path = to.String(entry["root"])
+		if list, ok := entry["aliases"].([]interface{}); ok {
+			for _, alias := range list {
+				aliases[name] = append(aliases[name], to.String(alias))
+			}
+		}
+		canonical[name] = to.Bool(entry["canonical"])
+	default:
+		path = to.String(entry)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to validate host %s: %q", name, err)
+	}
+	if info.IsDir() == false {
+		return "", fmt.Errorf("host %s does not point to a directory", name)
+	}
+	return path, nil
+}
+
+// stopLiveReload ends the live reload streams of all hosts, so that servers
+// shutting down don't wait for them.
+func stopLiveReload() {
+	hostsLock.RLock()
+	defer hostsLock.RUnlock()
+	for name := range hosts {
+		hosts[name].StopLiveReload()
 	}
 }
 
 // Loads settings
 func loadSettings() (dig.InterfaceMap, error) {
 
-	var entries map[string]interface{}
-	var ok bool
-
 	// Trying to read settings from file.
 	_, err := os.Stat(*flagSettings)
 	var y dig.InterfaceMap
@@ -132,46 +272,165 @@ func loadSettings() (dig.InterfaceMap, error) {
 		return nil, fmt.Errorf(`error trying to open settings file (%s): %q`, *flagSettings, err)
 	}
 
-	// Loading and verifying host entries
-	e := y.Get("hosts")
-	if entries, ok = e.(map[string]interface{}); !ok {
-		return nil, errors.New("missing 'hosts' entry")
+	// Loading and verifying host entries, including the sites found in
+	// sites_dir.
+	entries, discovered, err := hostEntries(y)
+	if err != nil {
+		return nil, err
 	}
 
 	h := map[string]*host.Host{}
+	aliases := map[string][]string{}
+	canonical := map[string]bool{}
 
 	// Populating host entries.
 	for name := range entries {
-		path := to.String(entries[name])
+		path, err := hostEntry(name, entries[name], aliases, canonical)
+		if err == nil {
+			var created *host.Host
+			if created, err = host.New(name, path); err == nil {
+				h[name] = created
+			}
+		}
 
-		info, err := os.Stat(path)
+		// A broken site dropped into sites_dir doesn't keep the others
+		// from being served.
+		if err != nil && discovered[name] {
+			log.Printf("Skipping site %s: %v\n", name, err)
+			delete(aliases, name)
+			delete(canonical, name)
+			continue
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to validate host %s: %q", name, err)
+			for name := range h {
+				h[name].Close()
+			}
+			return nil, fmt.Errorf("failed to initialize host %s: %q", name, err)
 		}
-		if info.IsDir() == false {
-			return nil, fmt.Errorf("host %s does not point to a directory", name)
+	}
+
+	r, err := buildRoutes(h, aliases, canonical)
+	if err != nil {
+		for name := range h {
+			h[name].Close()
 		}
+		return nil, err
+	}
 
-		h[name], err = host.New(name, path)
+	// Global access log; the common format on stdout unless configured.
+	conf, _ := y.Get("access_log").(map[string]interface{})
+	logger, err := accesslog.New(conf)
+	if err != nil {
+		for name := range h {
+			h[name].Close()
+		}
+		return nil, err
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to initialize host %s: %q", name, err)
+	// Optional metrics endpoint.
+	mpath := ""
+	if m, ok := y.Get("metrics").(map[string]interface{}); ok {
+		if mpath = to.String(m["path"]); mpath == "" {
+			mpath = envMetricsPath
 		}
 	}
 
+	// Optional admin API, which requires a token.
+	var a adminConfig
+	if m, ok := y.Get("admin").(map[string]interface{}); ok {
+		if a.token = to.String(m["token"]); a.token == "" {
+			log.Printf("Warning: admin API disabled, no token was provided.\n")
+		} else if a.path = strings.TrimRight(to.String(m["path"]), "/"); a.path == "" {
+			a.path = envAdminPath
+		}
+	}
+
+	// Optional response compression.
+	conf, _ = y.Get("compression").(map[string]interface{})
+	comp, err := loadCompression(conf)
+	if err != nil {
+		for name := range h {
+			h[name].Close()
+		}
+		logger.Close()
+		return nil, err
+	}
+
+	// Optional trusted reverse proxies and rate limits.
+	conf, _ = y.Get("proxies").(map[string]interface{})
+	px, err := loadProxies(conf)
+	var rl *rateLimits
+	if err == nil {
+		conf, _ = y.Get("rate_limit").(map[string]interface{})
+		rl, err = loadRateLimits(conf)
+	}
+	if err != nil {
+		for name := range h {
+			h[name].Close()
+		}
+		logger.Close()
+		return nil, err
+	}
+
+	// Pages reload in the browser when sites change in dev mode.
+	dev := to.Bool(y.Get("dev_mode"))
+	if dev {
+		for name := range h {
+			h[name].LiveReload()
+		}
+	}
+
+	hostsLock.Lock()
 	for name := range hosts {
 		hosts[name].Close()
 	}
+	if accessLog != nil {
+		accessLog.Close()
+	}
 
 	hosts = h
-
-	if _, ok := hosts["default"]; ok == false {
+	routes = r
+	accessLog = logger
+	metricsPath = mpath
+	admin = a
+	compression = comp
+	devMode = dev
+	sitesDir = to.String(y.Get("sites_dir"))
+	proxies = px
+	rl.reuse(limits)
+	limits = rl
+	hostsLock.Unlock()
+
+	if _, ok := hosts[defaultHost]; ok == false {
 		log.Printf("Warning: default host was not provided.\n")
 	}
 
 	return y, nil
 }
 
+// reloadSettings reloads settings.yaml and every host with its site.yaml and
+// templates. The current settings are kept if anything fails to load.
+func reloadSettings() error {
+	y, err := loadSettings()
+
+	hostsLock.Lock()
+	settingsLoaded = time.Now()
+	settingsErr = err
+	hostsLock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	settings = y
+	host.Reloads.Inc("", "settings")
+
+	hostsLock.RLock()
+	dir := sitesDir
+	hostsLock.RUnlock()
+	sitesWatch.watch(dir)
+	return nil
+}
+
 func settingsWatcher() (*fsnotify.Watcher, error) {
 
 	var err error
@@ -189,11 +448,8 @@ func settingsWatcher() (*fsnotify.Watcher, error) {
 						return
 					}
 
-					y, err := loadSettings()
-					if err != nil {
+					if err := reloadSettings(); err != nil {
 						log.Printf("Error loading settings file %s: %q\n", ev.Name, err)
-					} else {
-						settings = y
 					}
 				case err, ok := <-watcher.Errors:
 					if !ok {
@@ -0,0 +1,138 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lnxjedi/luminos/accesslog"
+	"github.com/lnxjedi/luminos/host"
+)
+
+// newTestSite loads a host named name for a temporary site made of files, as
+// written by writeTestSite.
+func newTestSite(t *testing.T, name string, files map[string]string) *host.Host {
+	t.Helper()
+	h, err := host.New(name, writeTestSite(t, files))
+	if err != nil {
+		t.Fatalf("host.New: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+// writeTestSite creates a temporary site made of files, given by their path
+// below the site's root, and returns its root. The site gets a minimal
+// site.yaml and index.tpl unless files has its own.
+func writeTestSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	site := map[string]string{
+		"site.yaml":           "title: Test\n",
+		"templates/index.tpl": "{{ .Content }}",
+	}
+	for file, content := range files {
+		site[file] = content
+	}
+	for file, content := range site {
+		full := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// useHosts routes requests to hosts, logging them to a temporary file, until
+// the test ends.
+func useHosts(t *testing.T, list ...*host.Host) {
+	t.Helper()
+	h := map[string]*host.Host{}
+	for _, each := range list {
+		h[each.Name] = each
+	}
+	table, err := buildRoutes(h, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := accesslog.New(map[string]interface{}{"file": filepath.Join(t.TempDir(), "access.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostsLock.Lock()
+	savedHosts, savedRoutes, savedLog := hosts, routes, accessLog
+	hosts, routes, accessLog = h, table, logger
+	hostsLock.Unlock()
+	t.Cleanup(func() {
+		hostsLock.Lock()
+		hosts, routes, accessLog = savedHosts, savedRoutes, savedLog
+		hostsLock.Unlock()
+		logger.Close()
+	})
+}
+
+// serve sends a request through a listener serving all hosts and returns
+// the response.
+func serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	server{}.ServeHTTP(w, req)
+	return w
+}
+
+// loadTestSettings loads content as settings.yaml, replacing the hosts and
+// server settings until the test ends. Paths in it should be absolute.
+func loadTestSettings(t *testing.T, content string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "settings.yaml")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := *flagSettings
+	*flagSettings = file
+	t.Cleanup(func() {
+		hostsLock.Lock()
+		for name := range hosts {
+			hosts[name].Close()
+		}
+		if accessLog != nil {
+			accessLog.Close()
+		}
+		hosts, routes, accessLog = nil, nil, nil
+		metricsPath, admin, compression, devMode = "", adminConfig{}, nil, false
+		sitesDir, proxies, limits = "", nil, nil
+		hostsLock.Unlock()
+		settings = nil
+		*flagSettings = saved
+	})
+	if err := reloadSettings(); err != nil {
+		t.Fatalf("loading settings: %v", err)
+	}
+}
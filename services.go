@@ -0,0 +1,128 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"sync/atomic"
+	"time"
+)
+
+// How often a FastCGI service checks for in-flight requests while draining.
+const drainInterval = 100 * time.Millisecond
+
+// service is a server bound to a network listener.
+type service interface {
+	// serve accepts connections until the service is shut down; it returns
+	// nil after a shutdown.
+	serve() error
+	// shutdown stops accepting connections and waits for in-flight requests
+	// to finish, giving up when ctx expires.
+	shutdown(ctx context.Context) error
+	// String describes the service for logging.
+	String() string
+}
+
+// httpService serves HTTP or HTTPS with a http.Server.
+type httpService struct {
+	listener net.Listener
+	server   *http.Server
+	// Set for HTTPS. The server's TLSConfig can't tell, since serving plain
+	// HTTP fills it in for HTTP/2.
+	https bool
+}
+
+func (s *httpService) serve() error {
+	var err error
+	if s.https {
+		err = s.server.ServeTLS(s.listener, "", "")
+	} else {
+		err = s.server.Serve(s.listener)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *httpService) shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *httpService) String() string {
+	if s.https {
+		return fmt.Sprintf("HTTPS server at %s", s.listener.Addr())
+	}
+	return fmt.Sprintf("HTTP server at %s", s.listener.Addr())
+}
+
+// fcgiService serves FastCGI and keeps count of in-flight requests, since
+// the fcgi package has no way of shutting down gracefully.
+type fcgiService struct {
+	listener net.Listener
+	handler  http.Handler
+	inflight int64
+	closed   int32
+}
+
+// ServeHTTP counts the request while the handler serves it.
+func (s *fcgiService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+	s.handler.ServeHTTP(w, req)
+}
+
+func (s *fcgiService) serve() error {
+	err := fcgi.Serve(s.listener, s)
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return nil
+	}
+	return err
+}
+
+func (s *fcgiService) shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.closed, 1)
+	s.listener.Close()
+
+	// Live reload streams stay open until their clients go away, so they're
+	// ended like http.Server does for the HTTP listeners.
+	stopLiveReload()
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&s.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *fcgiService) String() string {
+	return fmt.Sprintf("FastCGI server at %s", s.listener.Addr())
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ghodss/yaml"
+	"github.com/lnxjedi/dig"
+	"github.com/lnxjedi/to"
+)
+
+// Name of the settings file of a site.
+const siteSettingsFile = "site.yaml"
+
+// Time to wait for more changes in sites_dir before reloading, so that a
+// site being copied in is loaded once it's complete.
+const sitesReloadDelay = 500 * time.Millisecond
+
+// Directory whose subdirectories are sites, from settings.yaml.
+var sitesDir string
+
+// hostEntries returns the host entries of settings.yaml, adding those of
+// the sites found in sites_dir, and the names of the sites that were found
+// rather than listed.
+func hostEntries(y dig.InterfaceMap) (map[string]interface{}, map[string]bool, error) {
+	listed, ok := y.Get("hosts").(map[string]interface{})
+	dir := to.String(y.Get("sites_dir"))
+	if !ok && dir == "" {
+		return nil, nil, fmt.Errorf("missing 'hosts' entry")
+	}
+
+	entries := map[string]interface{}{}
+	for name, entry := range listed {
+		entries[name] = entry
+	}
+	discovered := map[string]bool{}
+	if dir == "" {
+		return entries, discovered, nil
+	}
+
+	found, err := discoverSites(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, entry := range found {
+		if _, ok := entries[name]; ok {
+			log.Printf("Warning: site %s in %s is already listed under hosts.\n", name, dir)
+			continue
+		}
+		entries[name] = entry
+		discovered[name] = true
+	}
+	return entries, discovered, nil
+}
+
+// discoverSites returns a host entry for every subdirectory of dir with a
+// site.yaml. A site is named after its directory unless its site.yaml sets
+// "route", like "docs.example.org" or "example.org/docs"; it may also set
+// "aliases" and "canonical" like entries under hosts. Directories starting
+// with "." or "_" are skipped.
+func discoverSites(dir string) (map[string]interface{}, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading sites_dir %s: %v", dir, err)
+	}
+
+	sites := map[string]interface{}{}
+	for _, file := range files {
+		name := file.Name()
+		if !file.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		root := path.Join(dir, name)
+
+		// Sites are only picked up once their site.yaml is in place. One
+		// that can't be read keeps the name of its directory, and fails
+		// to load as a host.
+		var conf map[string]interface{}
+		ydata, err := ioutil.ReadFile(path.Join(root, siteSettingsFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			yaml.Unmarshal(ydata, &conf)
+		}
+
+		if route := strings.TrimRight(to.String(conf["route"]), "/"); route != "" {
+			name = route
+		}
+		if _, ok := sites[name]; ok {
+			return nil, fmt.Errorf("more than one site in %s is routed at %s", dir, name)
+		}
+		sites[name] = map[string]interface{}{
+			"root":      root,
+			"aliases":   conf["aliases"],
+			"canonical": conf["canonical"],
+		}
+	}
+	return sites, nil
+}
+
+// siteWatcher reloads settings when sites appear in or disappear from
+// sites_dir, or change their site.yaml.
+type siteWatcher struct {
+	sync.Mutex
+	watcher *fsnotify.Watcher
+	// Directory currently watched.
+	dir string
+	// Pending reload, waiting for changes to settle.
+	timer *time.Timer
+}
+
+// Watcher of sites_dir; nil unless luminos is serving.
+var sitesWatch *siteWatcher
+
+func newSiteWatcher() (*siteWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	sw := &siteWatcher{watcher: watcher}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if sw.affects(ev.Name) {
+					sw.schedule()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Watcher error: %q\n", err)
+			}
+		}
+	}()
+
+	return sw, nil
+}
+
+// watch makes the watcher follow dir and its subdirectories; an empty dir
+// stops watching.
+func (sw *siteWatcher) watch(dir string) {
+	if sw == nil {
+		return
+	}
+	sw.Lock()
+	defer sw.Unlock()
+
+	if sw.dir != "" && sw.dir != dir {
+		sw.watcher.Remove(sw.dir)
+		if subdirs, err := ioutil.ReadDir(sw.dir); err == nil {
+			for _, sub := range subdirs {
+				sw.watcher.Remove(path.Join(sw.dir, sub.Name()))
+			}
+		}
+	}
+	sw.dir = dir
+	if dir == "" {
+		return
+	}
+
+	if err := sw.watcher.Add(dir); err != nil {
+		log.Printf("Could not watch sites_dir %s: %v\n", dir, err)
+		return
+	}
+	// Subdirectories are watched for their site.yaml.
+	if subdirs, err := ioutil.ReadDir(dir); err == nil {
+		for _, sub := range subdirs {
+			if sub.IsDir() {
+				sw.watcher.Add(path.Join(dir, sub.Name()))
+			}
+		}
+	}
+}
+
+// affects returns true if a change to file may add, remove or move a site:
+// a change to an entry of sites_dir or to the site.yaml of a subdirectory.
+func (sw *siteWatcher) affects(file string) bool {
+	sw.Lock()
+	dir := sw.dir
+	sw.Unlock()
+	if dir == "" {
+		return false
+	}
+	parent := filepath.Dir(file)
+	if parent == filepath.Clean(dir) {
+		return true
+	}
+	return filepath.Base(file) == siteSettingsFile && filepath.Dir(parent) == filepath.Clean(dir)
+}
+
+// schedule reloads settings after sitesReloadDelay, or pushes back the
+// reload already scheduled.
+func (sw *siteWatcher) schedule() {
+	sw.Lock()
+	defer sw.Unlock()
+	if sw.timer != nil {
+		sw.timer.Stop()
+	}
+	dir := sw.dir
+	sw.timer = time.AfterFunc(sitesReloadDelay, func() {
+		log.Printf("Sites changed in %s, reloading settings.\n", dir)
+		if err := reloadSettings(); err != nil {
+			log.Printf("Error loading settings file %s: %q\n", *flagSettings, err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/lnxjedi/dig"
+)
+
+// writeSitesDir creates a sites_dir with a site for every name in sites,
+// given the content of its site.yaml; names ending in "/" are directories
+// without a site.yaml.
+func writeSitesDir(t *testing.T, sites map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, conf := range sites {
+		root := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Join(root, "templates"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		files := map[string]string{
+			"site.yaml":           conf,
+			"templates/index.tpl": "{{ .Content }}",
+			"content/index.md":    "# " + name + "\n",
+		}
+		for file, content := range files {
+			full := filepath.Join(root, file)
+			os.MkdirAll(filepath.Dir(full), 0755)
+			if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestDiscoverSites(t *testing.T) {
+	dir := writeSitesDir(t, map[string]string{
+		"docs.example.org": "title: Docs\n",
+		"blog":             "title: Blog\nroute: \"example.org/blog/\"\naliases: [\"www.example.org/blog\"]\ncanonical: true\n",
+		"broken":           "route: [\n",
+		".hidden":          "title: Hidden\n",
+		"_draft":           "title: Draft\n",
+		"empty/":           "",
+	})
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("Sites\n"), 0644)
+
+	sites, err := discoverSites(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range sites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// A site.yaml that can't be read leaves the name of the directory.
+	if got := strings.Join(names, " "); got != "broken docs.example.org example.org/blog" {
+		t.Errorf("sites = %q", got)
+	}
+	blog := sites["example.org/blog"].(map[string]interface{})
+	if blog["root"] != filepath.Join(dir, "blog") || blog["canonical"] != true || fmt.Sprint(blog["aliases"]) != "[www.example.org/blog]" {
+		t.Errorf("example.org/blog = %v", blog)
+	}
+
+	if _, err := discoverSites(filepath.Join(dir, "missing")); err == nil {
+		t.Error("discoverSites succeeded on a missing directory")
+	}
+	dir = writeSitesDir(t, map[string]string{
+		"a": "route: example.org\n",
+		"b": "route: example.org/\n",
+	})
+	if _, err := discoverSites(dir); err == nil {
+		t.Error("discoverSites succeeded with two sites at the same route")
+	}
+}
+
+func TestHostEntries(t *testing.T) {
+	dir := writeSitesDir(t, map[string]string{
+		"default":          "title: Default\n",
+		"docs.example.org": "title: Docs\n",
+	})
+	entries := func(conf string) (map[string]interface{}, map[string]bool, error) {
+		var y dig.InterfaceMap
+		if err := yaml.Unmarshal([]byte(conf), &y); err != nil {
+			t.Fatal(err)
+		}
+		return hostEntries(y)
+	}
+
+	// Hosts listed in settings.yaml win over the sites found.
+	got, discovered, err := entries(fmt.Sprintf("sites_dir: %q\nhosts:\n  default: \"/srv/default\"\n", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[defaultHost] != "/srv/default" || discovered[defaultHost] || !discovered["docs.example.org"] {
+		t.Errorf("entries = %v, discovered %v", got, discovered)
+	}
+
+	if got, _, err := entries(fmt.Sprintf("sites_dir: %q\n", dir)); err != nil || len(got) != 2 {
+		t.Errorf("sites_dir alone = %v, %v", got, err)
+	}
+	if _, _, err := entries("server:\n  port: 80\n"); err == nil {
+		t.Error("hostEntries succeeded without hosts or sites_dir")
+	}
+}
+
+func TestSitesDirReload(t *testing.T) {
+	dir := writeSitesDir(t, map[string]string{
+		"default": "title: Default\n",
+		// Sites that fail to load are skipped.
+		"broken.example.org": "title: Broken\ncontent:\n  templates: \"missing\"\n",
+	})
+	loadTestSettings(t, fmt.Sprintf("sites_dir: %q\n", dir))
+
+	get := func(host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		return serve(req)
+	}
+	if w := get("localhost"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "default") {
+		t.Errorf("default = %d %q", w.Code, w.Body.String())
+	}
+	hostsLock.RLock()
+	_, loaded := hosts["broken.example.org"]
+	hostsLock.RUnlock()
+	if loaded {
+		t.Error("the broken site was loaded")
+	}
+
+	// New sites are served once settings are reloaded.
+	added := writeSitesDir(t, map[string]string{"docs": "route: docs.example.org\n"})
+	if err := os.Rename(filepath.Join(added, "docs"), filepath.Join(dir, "docs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("docs.example.org"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "docs") {
+		t.Errorf("docs.example.org = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestSiteWatcherAffects(t *testing.T) {
+	sw := &siteWatcher{dir: "/srv/sites"}
+	for file, want := range map[string]bool{
+		"/srv/sites/docs":                   true,
+		"/srv/sites/docs/site.yaml":         true,
+		"/srv/sites/docs/content/index.md":  false,
+		"/srv/sites/docs/content/site.yaml": false,
+		"/srv/other/site.yaml":              false,
+	} {
+		if got := sw.affects(file); got != want {
+			t.Errorf("affects(%q) = %v, want %v", file, got, want)
+		}
+	}
+	if (&siteWatcher{}).affects("/srv/sites/docs") {
+		t.Error("a watcher without a directory is affected by changes")
+	}
+}
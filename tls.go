@@ -0,0 +1,136 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/lnxjedi/to"
+)
+
+// Supported values for server.tls.min_version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certificateStore picks a certificate by the server name the client asked
+// for (SNI).
+type certificateStore map[string]*tls.Certificate
+
+// getCertificate returns the certificate for an exact name, then for a
+// wildcard matching the name, then the "default" certificate.
+func (c certificateStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	if cert, ok := c[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i > -1 {
+		if cert, ok := c["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	if cert, ok := c[defaultHost]; ok {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+}
+
+// loadTLSConfig reads the "tls" section of a server block. It returns nil
+// when no certificates are configured.
+func loadTLSConfig(conf map[string]interface{}) (*tls.Config, error) {
+	entries, ok := conf["certificates"].(map[string]interface{})
+	if !ok || len(entries) == 0 {
+		return nil, nil
+	}
+
+	store := certificateStore{}
+	for name, entry := range entries {
+		files, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("certificate for %s must provide cert and key", name)
+		}
+		cert, err := tls.LoadX509KeyPair(to.String(files["cert"]), to.String(files["key"]))
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate for %s: %v", name, err)
+		}
+		store[strings.ToLower(name)] = &cert
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if v := to.String(conf["min_version"]); v != "" {
+		if minVersion, ok = tlsVersions[v]; !ok {
+			return nil, fmt.Errorf("unsupported TLS version: %s", v)
+		}
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: store.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// httpsRedirect redirects plain HTTP requests to the same URL over HTTPS.
+type httpsRedirect struct {
+	// Port of the HTTPS listener; omitted from URLs when it's 443.
+	port string
+}
+
+// newHTTPSRedirect returns a redirect handler for an HTTPS listener bound
+// to address.
+func newHTTPSRedirect(address string) (*httpsRedirect, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil || strings.HasPrefix(address, unixPrefix) {
+		return nil, errors.New("HTTPS redirect requires a TCP listener")
+	}
+	if port == "443" {
+		port = ""
+	}
+	return &httpsRedirect{port: port}, nil
+}
+
+// ServeHTTP sends a permanent redirect to the HTTPS version of the URL.
+func (h *httpsRedirect) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := req.Host
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	// IPv6 addresses are bracketed in URLs, with or without a port.
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+	if h.port != "" {
+		name = net.JoinHostPort(name, h.port)
+	} else if strings.Contains(name, ":") {
+		name = "[" + name + "]"
+	}
+	target := *req.URL
+	target.Scheme = "https"
+	target.Host = name
+	http.Redirect(w, req, target.String(), http.StatusMovedPermanently)
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2018 David Parsley
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for name, and its key,
+// to dir and returns their files.
+func writeCertificate(t *testing.T, dir, name string) map[string]interface{} {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := filepath.Join(dir, filepath.Base(name))
+	files := map[string]interface{}{"cert": base + ".pem", "key": base + ".key"}
+	blocks := map[string]*pem.Block{
+		base + ".pem": {Type: "CERTIFICATE", Bytes: der},
+		base + ".key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for file, block := range blocks {
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+// commonName returns the name a certificate was issued for.
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certs := map[string]interface{}{
+		"default":         writeCertificate(t, dir, "default"),
+		"Foo.Example.org": writeCertificate(t, dir, "foo.example.org"),
+		"*.example.org":   writeCertificate(t, dir, "wildcard.example.org"),
+	}
+
+	if config, err := loadTLSConfig(map[string]interface{}{}); config != nil || err != nil {
+		t.Errorf("without certificates = %v, %v; want no TLS", config, err)
+	}
+
+	config, err := loadTLSConfig(map[string]interface{}{"certificates": certs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("default min version = %x, want TLS 1.2", config.MinVersion)
+	}
+
+	// Certificates are picked by the name the client asks for.
+	tests := []struct {
+		server, want string
+	}{
+		{"foo.example.org", "foo.example.org"},
+		{"FOO.example.org", "foo.example.org"},
+		{"bar.example.org", "wildcard.example.org"},
+		{"a.bar.example.org", "default"},
+		{"example.net", "default"},
+		{"", "default"},
+	}
+	for _, test := range tests {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: test.server})
+		if err != nil {
+			t.Errorf("certificate for %q: %v", test.server, err)
+			continue
+		}
+		if got := commonName(t, cert); got != test.want {
+			t.Errorf("certificate for %q is %s, want %s", test.server, got, test.want)
+		}
+	}
+
+	config, err = loadTLSConfig(map[string]interface{}{
+		"min_version":  "1.3",
+		"certificates": map[string]interface{}{"foo.example.org": certs["Foo.Example.org"]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("min version = %x, want TLS 1.3", config.MinVersion)
+	}
+	if _, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.net"}); err == nil {
+		t.Error("got a certificate for an unknown name without a default")
+	}
+
+	invalid := []map[string]interface{}{
+		{"min_version": "0.9", "certificates": certs},
+		{"certificates": map[string]interface{}{"default": "cert.pem"}},
+		{"certificates": map[string]interface{}{"default": map[string]interface{}{
+			"cert": filepath.Join(dir, "missing.pem"), "key": filepath.Join(dir, "missing.key"),
+		}}},
+	}
+	for _, conf := range invalid {
+		if _, err := loadTLSConfig(conf); err == nil {
+			t.Errorf("%v was accepted", conf)
+		}
+	}
+}
+
+func TestServeHTTPS(t *testing.T) {
+	useHosts(t, newTestSite(t, defaultHost, map[string]string{
+		"content/index.md": "# Home\n",
+	}))
+	dir := t.TempDir()
+	services, err := newServices(map[string]interface{}{
+		"protocol": "https",
+		"address":  "127.0.0.1:0",
+		"tls": map[string]interface{}{
+			"redirect": "127.0.0.1:0",
+			"certificates": map[string]interface{}{
+				"default":         writeCertificate(t, dir, "default"),
+				"foo.example.org": writeCertificate(t, dir, "foo.example.org"),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("got %d services, want HTTPS and its redirect", len(services))
+	}
+	for _, s := range services {
+		go s.serve()
+		defer s.shutdown(context.Background())
+	}
+	addr := services[0].(*httpService).listener.Addr().String()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         "foo.example.org",
+		}},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET over HTTPS = %d, want 200", resp.StatusCode)
+	}
+	if name := resp.TLS.PeerCertificates[0].Subject.CommonName; name != "foo.example.org" {
+		t.Errorf("served the certificate of %s, want foo.example.org", name)
+	}
+
+	redirect := services[1].(*httpService).listener.Addr().String()
+	resp, err = client.Get("http://" + redirect + "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("GET over HTTP = %d, want a redirect", resp.StatusCode)
+	}
+	// The port of the HTTPS listener is the one configured, ":0" here.
+	if loc := resp.Header.Get("Location"); loc != "https://127.0.0.1:0/docs" {
+		t.Errorf("redirected to %s, want the HTTPS URL", loc)
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		address, host, target, location string
+	}{
+		{":443", "example.org", "/docs?q=1", "https://example.org/docs?q=1"},
+		{":443", "example.org:80", "/", "https://example.org/"},
+		{":8443", "example.org:8080", "/", "https://example.org:8443/"},
+		{":443", "[::1]", "/", "https://[::1]/"},
+		{":443", "[::1]:80", "/", "https://[::1]/"},
+		{":8443", "[::1]", "/", "https://[::1]:8443/"},
+		{":8443", "[2001:db8::1]:8080", "/a", "https://[2001:db8::1]:8443/a"},
+	}
+	for _, test := range tests {
+		h, err := newHTTPSRedirect(test.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		req.Host = test.host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s on %s = %d, want 301", test.host, test.address, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != test.location {
+			t.Errorf("%s%s on %s redirected to %q, want %q", test.host, test.target, test.address, loc, test.location)
+		}
+	}
+
+	if _, err := newHTTPSRedirect("unix:/run/luminos.sock"); err == nil {
+		t.Error("redirect for a unix socket listener was accepted")
+	}
+}